@@ -0,0 +1,278 @@
+package accounts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultIterations is the PBKDF2 work factor of new password hashes
+	DefaultIterations = 600_000
+
+	// MinPasswordLength is the shortest password accepted, in characters
+	MinPasswordLength = 8
+
+	saltSize = 16
+	keySize  = 32
+	scheme   = "pbkdf2-sha256"
+)
+
+var (
+	// ErrRegistered is returned by Register for a name that already has an account
+	ErrRegistered = errors.New("name is already registered")
+
+	// ErrNoAccount is returned for a name without an account
+	ErrNoAccount = errors.New("name is not registered")
+
+	// ErrWrongPassword is returned when a password does not match the account
+	ErrWrongPassword = errors.New("wrong password")
+
+	// ErrShortPassword is returned for passwords under MinPasswordLength
+	ErrShortPassword = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+)
+
+// Account is a registered name and the hash of its password
+type Account struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+	Changed time.Time `json:"changed"`
+}
+
+// Store holds the accounts of a server. A store opened from a file saves
+// every change back to it.
+type Store struct {
+	// Iterations is the work factor used for new hashes. Existing hashes
+	// keep the work factor they were made with.
+	Iterations int
+
+	mu       sync.Mutex
+	path     string
+	accounts map[string]Account
+}
+
+// New creates an empty store that is kept in memory only
+func New() *Store {
+	return &Store{Iterations: DefaultIterations, accounts: make(map[string]Account)}
+}
+
+// Open loads the store saved at path, or starts an empty one if the file
+// does not exist yet
+func Open(path string) (*Store, error) {
+	s := New()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var list []Account
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		s.accounts[strings.ToLower(a.Name)] = a
+	}
+	return s, nil
+}
+
+// Registered reports whether name has an account, ignoring case
+func (s *Store) Registered(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.accounts[strings.ToLower(name)]
+	return ok
+}
+
+// Register creates an account for name
+func (s *Store) Register(name, password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return ErrShortPassword
+	}
+
+	if s.Registered(name) {
+		return ErrRegistered
+	}
+
+	// Hashing is slow on purpose, so it happens outside the lock
+	hash, err := Hash(password, s.Iterations)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(name)
+	if _, ok := s.accounts[key]; ok {
+		return ErrRegistered
+	}
+	now := time.Now()
+	s.accounts[key] = Account{Name: name, Hash: hash, Created: now, Changed: now}
+	if err := s.save(); err != nil {
+		delete(s.accounts, key)
+		return err
+	}
+	return nil
+}
+
+// Verify checks password against the account of name
+func (s *Store) Verify(name, password string) error {
+	s.mu.Lock()
+	account, ok := s.accounts[strings.ToLower(name)]
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrNoAccount
+	}
+	if !Check(account.Hash, password) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// ChangePassword replaces the password of name after checking the old one
+func (s *Store) ChangePassword(name, old, password string) error {
+	if err := s.Verify(name, old); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return ErrShortPassword
+	}
+
+	hash, err := Hash(password, s.Iterations)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(name)
+	account, ok := s.accounts[key]
+	if !ok {
+		return ErrNoAccount
+	}
+	previous := account
+	account.Hash = hash
+	account.Changed = time.Now()
+	s.accounts[key] = account
+	if err := s.save(); err != nil {
+		s.accounts[key] = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the accounts to the store's file, replacing it atomically.
+// The caller must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		list = append(list, a)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Hash derives a salted PBKDF2-HMAC-SHA256 hash of password, encoded as
+// "pbkdf2-sha256$<iterations>$<salt>$<key>"
+func Hash(password string, iterations int) (string, error) {
+	if iterations < 1 {
+		iterations = DefaultIterations
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, iterations, keySize)
+
+	encode := base64.RawStdEncoding.EncodeToString
+	return strings.Join([]string{scheme, strconv.Itoa(iterations), encode(salt), encode(key)}, "$"), nil
+}
+
+// Check reports whether password matches a hash made by Hash
+func Check(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != scheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2([]byte(password), salt, iterations, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2 derives a key of keyLen bytes from password as in RFC 8018
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	size := prf.Size()
+
+	var key []byte
+	u := make([]byte, size)
+	t := make([]byte, size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,172 @@
+package bans
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single ban on a name or an IP address
+type Entry struct {
+	Name   string    `json:"name,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	By     string    `json:"by"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+
+	// Until is when the ban expires; the zero time never expires
+	Until time.Time `json:"until"`
+}
+
+// Target returns the name or address the entry bans
+func (e Entry) Target() string {
+	if e.IP != "" {
+		return e.IP
+	}
+	return e.Name
+}
+
+// Expired reports whether the ban no longer applies at t
+func (e Entry) Expired(t time.Time) bool {
+	return !e.Until.IsZero() && !t.Before(e.Until)
+}
+
+// List holds the bans of a server. A list opened from a file saves every
+// change back to it, so bans survive restarts.
+type List struct {
+	mu      sync.Mutex
+	path    string
+	entries []Entry
+}
+
+// New creates an empty list that is kept in memory only
+func New() *List {
+	return &List{}
+}
+
+// Open loads the list saved at path, or starts an empty one if the file
+// does not exist yet
+func Open(path string) (*List, error) {
+	l := &List{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &l.entries); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Add bans the entry's name or IP, replacing any earlier ban on it
+func (l *List) Add(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(e.Target())
+	l.entries = append(l.entries, e)
+	return l.save()
+}
+
+// Remove lifts the ban on a name or IP and reports whether there was one
+func (l *List) Remove(target string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.remove(target) {
+		return false, nil
+	}
+	return true, l.save()
+}
+
+// Name returns the ban on a name, ignoring case
+func (l *List) Name(name string) (Entry, bool) {
+	return l.find(func(e Entry) bool { return e.Name != "" && strings.EqualFold(e.Name, name) })
+}
+
+// IP returns the ban on an IP address
+func (l *List) IP(ip string) (Entry, bool) {
+	return l.find(func(e Entry) bool { return e.IP != "" && e.IP == ip })
+}
+
+// Entries returns the bans still in force
+func (l *List) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var active []Entry
+	for _, e := range l.entries {
+		if !e.Expired(now) {
+			active = append(active, e)
+		}
+	}
+	return active
+}
+
+func (l *List) find(match func(Entry) bool) (Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range l.entries {
+		if match(e) && !e.Expired(now) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// remove drops the entries banning target. The caller must hold l.mu.
+func (l *List) remove(target string) bool {
+	kept := l.entries[:0]
+	for _, e := range l.entries {
+		if !strings.EqualFold(e.Target(), target) {
+			kept = append(kept, e)
+		}
+	}
+	removed := len(kept) < len(l.entries)
+	l.entries = kept
+	return removed
+}
+
+// save writes the bans still in force to the list's file, replacing it
+// atomically. The caller must hold l.mu.
+func (l *List) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	now := time.Now()
+	active := make([]Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		if !e.Expired(now) {
+			active = append(active, e)
+		}
+	}
+	l.entries = active
+
+	data, err := json.MarshalIndent(active, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(l.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
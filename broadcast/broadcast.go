@@ -2,21 +2,69 @@ package broadcast
 
 import (
 	"netcat/models"
+	"netcat/rooms"
+	"netcat/sessions"
 	"netcat/utils"
 )
 
-func Broadcaster() {
-	for msg := range models.Broadcast {
-		utils.LogToFile(msg)
+// Broadcaster relays every message on the hub's broadcast channel to the
+// members of its room until the channel is closed. Each member gets the
+// message rendered for them. A room is removed once the departure of its
+// last member has been announced.
+func Broadcaster(hub *models.Hub) {
+	for msg := range hub.Broadcast {
+		if msg.ID == 0 {
+			msg.ID = hub.NextID()
+		}
+
+		hub.Mu.Lock()
+		line := msg.Line()
+		record := line
+		if hub.EncodeLog != nil {
+			record = hub.EncodeLog(msg)
+		}
+		for _, room := range targets(hub, msg) {
+			utils.LogToFile(room, record)
+			room.History.Add(line)
 
-		models.Mu.Lock()
-		for conn := range models.Clients {
-			_, err := conn.Write([]byte(msg))
-			if err != nil {
-				conn.Close()
-				delete(models.Clients, conn)
+			for conn := range room.Members {
+				text := msg.Render(hub.Name(conn), hub.Prefs[conn])
+				if text == "" {
+					continue
+				}
+				// HandleClient sees the closed connection and cleans up,
+				// keeping the session if it can be resumed
+				if !utils.Deliver(hub, conn, text) {
+					conn.Close()
+				}
 			}
+			sessions.Record(hub, room.Name, msg)
+		}
+
+		// The last member to leave a room takes it with them
+		var dropped *models.Room
+		if msg.Kind == models.KindLeave {
+			dropped = rooms.Drop(hub, msg.Room)
+		}
+		hub.Mu.Unlock()
+		if dropped != nil {
+			rooms.CloseLog(hub, dropped)
 		}
-		models.Mu.Unlock()
 	}
 }
+
+// targets returns the rooms a message is delivered to. The caller must hold hub.Mu.
+func targets(hub *models.Hub, msg models.Message) []*models.Room {
+	if msg.Room != "" {
+		if room, ok := hub.Rooms[msg.Room]; ok {
+			return []*models.Room{room}
+		}
+		return nil
+	}
+
+	all := make([]*models.Room, 0, len(hub.Rooms))
+	for _, room := range hub.Rooms {
+		all = append(all, room)
+	}
+	return all
+}
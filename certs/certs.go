@@ -0,0 +1,182 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultValidity is how long a generated certificate is valid
+const DefaultValidity = 365 * 24 * time.Hour
+
+// Generate creates a self-signed certificate for hosts, which may be names
+// or IP addresses, and returns it and its private key PEM encoded. Such
+// certificates are meant for development; clients have to be told to
+// trust them.
+func Generate(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("at least one host is needed")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"TCPChat development"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// SelfSigned creates a self-signed certificate for hosts in memory, with
+// its parsed form in Leaf so callers can trust it
+func SelfSigned(hosts ...string) (tls.Certificate, error) {
+	certPEM, keyPEM, err := Generate(hosts, DefaultValidity)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+// ServerConfig returns the TLS configuration a server uses with cert
+func ServerConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// Load reads a PEM certificate and key from disk into a server configuration
+func Load(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return ServerConfig(cert), nil
+}
+
+// Run implements "gencert [flags]": it writes a self-signed development
+// certificate and key for the TLS listener
+func Run(args []string, stdout, stderr io.Writer) error {
+	var hosts, certFile, keyFile string
+	var validFor time.Duration
+	var force bool
+
+	fs := flag.NewFlagSet("gencert", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: TCPChat gencert [flags]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&hosts, "host", "localhost,127.0.0.1,::1", "names and IP addresses the certificate is for (comma separated)")
+	fs.StringVar(&certFile, "cert", "cert.pem", "file to write the certificate to")
+	fs.StringVar(&keyFile, "key", "key.pem", "file to write the private key to")
+	fs.DurationVar(&validFor, "valid-for", DefaultValidity, "how long the certificate is valid")
+	fs.BoolVar(&force, "force", false, "overwrite existing files")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("gencert takes no arguments")
+	}
+
+	if !force {
+		for _, path := range []string{certFile, keyFile} {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use -force to overwrite it)", path)
+			}
+		}
+	}
+
+	var names []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			names = append(names, host)
+		}
+	}
+	certPEM, keyPEM, err := Generate(names, validFor)
+	if err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	if err := writeFile(certFile, certPEM, flags, 0o644); err != nil {
+		return err
+	}
+	if err := writeFile(keyFile, keyPEM, flags, 0o600); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Wrote %s and %s for %s, valid until %s\n", certFile, keyFile, strings.Join(names, ", "), time.Now().Add(validFor).Format(time.DateOnly))
+	return nil
+}
+
+// writeFile writes data to a new file, or over an old one when flags allow it
+func writeFile(path string, data []byte, flags int, perm os.FileMode) error {
+	file, err := os.OpenFile(path, flags, perm)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use -force to overwrite it)", path)
+	} else if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,120 @@
+package chatlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"netcat/logfile"
+	"netcat/models"
+)
+
+// Format is how messages are written to the chat log
+type Format int
+
+const (
+	// Text logs the lines clients see, stamped in UTC
+	Text Format = iota
+
+	// JSON logs one JSON object per message (JSON Lines), keeping the
+	// sender, kind and metadata of every event
+	JSON
+)
+
+func (f Format) String() string {
+	if f == JSON {
+		return "json"
+	}
+	return "text"
+}
+
+// ParseFormat is the inverse of Format.String
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return Text, fmt.Errorf("unknown log format %q (want text or json)", s)
+}
+
+// Encoder returns the function that turns a message into a log record
+func Encoder(f Format) func(models.Message) string {
+	if f == JSON {
+		return Encode
+	}
+	return models.Message.Line
+}
+
+// Encode turns a message into a JSON Lines record
+func Encode(msg models.Message) string {
+	msg.Time = msg.Time.UTC()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		// Messages only hold strings, numbers and times
+		panic(err)
+	}
+	return string(data) + "\n"
+}
+
+// Decode parses a JSON Lines record
+func Decode(record string) (models.Message, error) {
+	var msg models.Message
+	err := json.Unmarshal([]byte(record), &msg)
+	return msg, err
+}
+
+// HistoryLine turns a record of either format into the chat line kept in
+// room history. Text records are already chat lines.
+func HistoryLine(record string) string {
+	if !strings.HasPrefix(record, "{") {
+		return record
+	}
+	msg, err := Decode(record)
+	if err != nil {
+		return record
+	}
+	return strings.TrimSuffix(msg.Line(), "\n")
+}
+
+// Read calls fn with every JSON record in the log at path and its rotated
+// segments, oldest first. Lines that are not JSON records, such as those
+// written before the log was switched to JSON, are skipped and counted.
+func Read(path string, fn func(models.Message) error) (skipped int, err error) {
+	segments := logfile.Segments(path)
+	if len(segments) == 0 {
+		return 0, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+
+	for _, segment := range segments {
+		file, err := os.Open(segment)
+		if err != nil {
+			return skipped, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			msg, err := Decode(scanner.Text())
+			if err != nil {
+				skipped++
+				continue
+			}
+			if err := fn(msg); err != nil {
+				file.Close()
+				return skipped, err
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return skipped, fmt.Errorf("%s: %w", segment, err)
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"netcat/accounts"
+	"netcat/input"
+	"netcat/models"
+	"netcat/utils"
+)
+
+// loginAttempts is how many wrong passwords a connection may send before
+// it is closed
+const loginAttempts = 3
+
+// login asks for the password of the registered name. It reports whether
+// the right password was given; otherwise the client has been told why
+// and the connection should be closed.
+func login(hub *models.Hub, conn net.Conn, reader *input.Reader, name string) bool {
+	for attempt := 1; attempt <= loginAttempts; attempt++ {
+		utils.Send(hub, conn, "[PASSWORD]: ")
+		line, err := reader.ReadLine()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			utils.Send(hub, conn, "\nTimed out waiting for a password. Connection closed.\n")
+			return false
+		}
+		if errors.Is(err, input.ErrTooLong) || errors.Is(err, input.ErrTruncated) {
+			utils.Send(hub, conn, "Wrong password.\n")
+			continue
+		}
+		if err != nil {
+			return false
+		}
+
+		// The password is compared as typed, not cleaned like chat text
+		if err := hub.Accounts.Verify(name, strings.TrimSpace(line)); err == nil {
+			return true
+		}
+		utils.Send(hub, conn, "Wrong password.\n")
+	}
+	utils.Send(hub, conn, "Too many wrong passwords. Connection closed.\n")
+	return false
+}
+
+// registerName handles "/register <password>", registering the client's
+// current name and logging it in to the new account
+func registerName(s *session, password string) {
+	if err := s.hub.Accounts.Register(s.name, password); err != nil {
+		utils.Send(s.hub, s.conn, describe(err))
+		return
+	}
+	s.account = s.name
+	utils.Send(s.hub, s.conn, "Your name "+s.name+" is now registered. You will be asked for the password when you next join with it.\n")
+}
+
+// changePassword handles "/passwd <old> <new>" for the account the client
+// is logged in to
+func changePassword(s *session, args string) {
+	if s.account == "" {
+		utils.Send(s.hub, s.conn, "You are not logged in to a registered name. Use /register <password> first.\n")
+		return
+	}
+
+	old, password, _ := strings.Cut(args, " ")
+	err := s.hub.Accounts.ChangePassword(s.account, old, strings.TrimSpace(password))
+	if errors.Is(err, accounts.ErrWrongPassword) {
+		utils.Send(s.hub, s.conn, "Your current password is wrong. The password was not changed.\n")
+		return
+	} else if err != nil {
+		utils.Send(s.hub, s.conn, describe(err))
+		return
+	}
+	utils.Send(s.hub, s.conn, "Your password has been changed.\n")
+}
@@ -1,82 +1,427 @@
 package client
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"netcat/history"
+	"netcat/input"
 	"netcat/models"
+	"netcat/moderation"
+	"netcat/names"
+	"netcat/outbox"
+	"netcat/presence"
+	"netcat/rooms"
+	"netcat/sanitize"
+	"netcat/sessions"
+	"netcat/style"
 	"netcat/utils"
 )
 
-func HandleClient(conn net.Conn, fileName string) {
+// HandleClient runs the session of a single connection on the given hub
+func HandleClient(hub *models.Hub, conn net.Conn) {
 	defer conn.Close()
-	reader := bufio.NewReader(conn)
+	reader := input.NewReader(conn, hub.MaxLine, hub.Oversize)
 
-	logo, _ := os.ReadFile("logo.txt")
+	// Messages from other clients are queued here so a slow reader never
+	// blocks the sender
+	hub.Mu.Lock()
+	box := outbox.New(conn, hub.Outbox)
+	hub.Outboxes[conn] = box
+	hub.Mu.Unlock()
 
-	conn.Write([]byte(string(logo) + "\n"))
-	conn.Write([]byte("[ENTER YOUR NAME]: "))
-	name, _ := reader.ReadString('\n')
-	name = strings.TrimSpace(name)
+	defer func() {
+		hub.Mu.Lock()
+		delete(hub.Outboxes, conn)
+		hub.Mu.Unlock()
+		box.Close()
+	}()
 
-	if name == "" {
-		conn.Write([]byte("Invalid name. Connection closed.\n"))
-		return
-	}
-
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	models.Mu.Lock()
-	models.Clients[conn] = name
-	models.Mu.Unlock()
-
-	utils.SendChatHistory(conn, fileName)
+	logo, _ := os.ReadFile(hub.BannerFile)
 
-	joinMsg := fmt.Sprintf("%s has joined our chat...\n", name)
-	utils.NotifyClients(conn, joinMsg)
+	utils.Send(hub, conn, string(logo)+"\n")
 
-	nameTag := "[" + name + "]"
+	// A connection that never picks a name must not hold its slot forever
+	if hub.NameTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(hub.NameTimeout))
+	}
 
+	// account is the registered name this client proved it owns, if any
+	var name, account string
+	var resumed *models.Session
 	for {
-		msg, err := reader.ReadString('\n')
+		utils.Send(hub, conn, "[ENTER YOUR NAME]: ")
+		line, err := reader.ReadLine()
+		if errors.Is(err, input.ErrTooLong) || errors.Is(err, input.ErrTruncated) {
+			utils.Send(hub, conn, "Invalid name: name is too long.\n")
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			utils.Send(hub, conn, "\nTimed out waiting for a name. Connection closed.\n")
+			return
+		}
 		if err != nil {
+			return
+		}
+
+		// A client whose connection dropped comes back with its token
+		if token, ok := strings.CutPrefix(strings.TrimSpace(line), "/resume "); ok {
+			session, ok, quit := resume(hub, conn, strings.TrimSpace(token))
+			if quit {
+				return
+			} else if !ok {
+				continue
+			}
+			resumed, name, account = session, session.Name, session.Account
 			break
 		}
 
-		msg = strings.TrimSpace(msg)
-		if msg == "" {
+		name = strings.TrimSpace(sanitize.Clean(line))
+		if name == "" {
+			utils.Send(hub, conn, "Invalid name: name cannot be empty.\n")
+			continue
+		}
+		if entry, banned := hub.Bans.Name(name); banned {
+			utils.Send(hub, conn, moderation.BanNotice(entry))
+			return
+		}
+		registered := hub.Accounts.Registered(name)
+		if registered {
+			// Nobody is asked for the password of a name already in use
+			if err := names.Available(hub, conn, name); err != nil {
+				utils.Send(hub, conn, describe(err))
+				continue
+			}
+			if !login(hub, conn, reader, name) {
+				return
+			}
+		}
+		if _, err := names.Claim(hub, conn, name); err != nil {
+			utils.Send(hub, conn, describe(err))
 			continue
 		}
+		if registered {
+			account = name
+		}
+		break
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// A resumed session is back in its room already, so nobody is told
+	if resumed == nil {
+		lobby, _, err := rooms.Join(hub, conn, models.Lobby)
+		if err != nil {
+			utils.Send(hub, conn, "Unable to join the lobby. Connection closed.\n")
+			hub.Mu.Lock()
+			delete(hub.Clients, conn)
+			hub.Mu.Unlock()
+			return
+		}
+
+		if hub.Features.History {
+			utils.SendRecentHistory(hub, conn, lobby, hub.HistoryLines)
+		}
+
+		hub.Broadcast <- models.NewMessage(models.KindJoin, name, models.Lobby, "")
+	}
+	issueToken(hub, conn)
+
+	s := &session{hub: hub, conn: conn, name: name, account: account}
 
-		if msg == "/quit" {
+	guard := hub.Flood.Guard(moderation.Host(conn.RemoteAddr()))
+	defer guard.Close()
+
+	// A client that stays quiet long enough is marked as away
+	var idle *time.Timer
+	if hub.AutoAway > 0 {
+		idle = time.AfterFunc(hub.AutoAway, func() {
+			if presence.MarkIdle(hub, conn) {
+				utils.Send(hub, conn, fmt.Sprintf("[You were marked as away after %s without activity.]\n", moderation.FormatDuration(hub.AutoAway)))
+			}
+		})
+		defer idle.Stop()
+	}
+
+	// dropped is set when the connection fails rather than the client quitting
+	dropped := false
+	for !s.quit {
+		msg, err := reader.ReadLine()
+		if errors.Is(err, input.ErrTooLong) {
+			utils.Send(hub, conn, fmt.Sprintf("Your message is longer than %d bytes and was not sent.\n", reader.Max()))
+			continue
+		} else if errors.Is(err, input.ErrTruncated) {
+			utils.Send(hub, conn, fmt.Sprintf("Your message was cut to %d bytes.\n", reader.Max()))
+		} else if err != nil {
+			dropped = true
 			break
-		} else if strings.HasPrefix(msg, "/rename ") {
-			newName := strings.TrimPrefix(msg, "/rename ")
+		}
 
-			if newName == "" {
-				conn.Write([]byte("Invalid name. Usage: /rename <new_name>\n"))
+		// Nothing a client sends may reach another terminal as a control
+		// sequence. Passwords are the exception, as they are never shown.
+		raw := msg
+		msg = strings.TrimSpace(sanitize.Clean(msg))
+		if msg == "" {
+			continue
+		}
+		if presence.Touch(hub, conn) {
+			utils.Send(hub, conn, "[You are no longer marked as away.]\n")
+		}
+		if idle != nil {
+			idle.Reset(hub.AutoAway)
+		}
+
+		// A line starting with "//" is chat that begins with a "/"
+		isCommand := strings.HasPrefix(msg, "/") && !strings.HasPrefix(msg, "//")
+		text := msg
+		if isCommand {
+			cmd, _, _ := parseCommand(msg)
+			if cmd != nil && cmd.unlimited {
+				s.dispatch(msg, raw)
 				continue
 			}
+			if cmd == nil || !cmd.chat {
+				text = ""
+			}
+		}
+		if ok, quit := checkFlood(hub, conn, guard, s.name, text); quit {
+			break
+		} else if !ok {
+			continue
+		}
 
-			models.Mu.Lock()
-			oldName := models.Clients[conn]
-			models.Clients[conn] = newName
-			models.Mu.Unlock()
+		if isCommand {
+			s.dispatch(msg, raw)
+			continue
+		}
+		if strings.HasPrefix(msg, "//") {
+			msg = msg[1:]
+		}
+
+		if remaining, muted := moderation.MutedFor(hub, s.name); muted {
+			utils.Send(hub, conn, mutedNotice(remaining))
+			continue
+		}
 
-			utils.NotifyClients(conn, fmt.Sprintf("%s has changed their name to %s\n", oldName, newName))
-			nameTag = "[" + newName + "]"
+		hub.Broadcast <- models.NewMessage(models.KindChat, s.name, rooms.Current(hub, conn), msg)
+		for _, away := range presence.Mentioned(hub, conn, msg) {
+			utils.Send(hub, conn, presence.AwayNotice(away.Name, away.Away))
 		}
+	}
+	account = s.account
 
-		models.Broadcast <- fmt.Sprintf("[%s]%s: %s\n", timestamp, nameTag, msg)
+	// A dropped client keeps its place for a while in case it comes back
+	if dropped && sessions.Detach(hub, conn, account) {
+		return
 	}
 
-	models.Mu.Lock()
-	delete(models.Clients, conn)
-	models.Mu.Unlock()
+	hub.Mu.Lock()
+	sessions.Revoke(hub, conn)
+	delete(hub.Clients, conn)
+	delete(hub.ReplyTo, conn)
+	delete(hub.Prefs, conn)
+	delete(hub.Operators, conn)
+	room := rooms.Remove(hub, conn)
+	hub.Mu.Unlock()
 
-	leaveMsg := fmt.Sprintf("%s has left our chat.\n", name)
-	utils.NotifyClients(conn, leaveMsg)
+	// An empty room would announce the departure in every room
+	if room != "" {
+		hub.Broadcast <- models.NewMessage(models.KindLeave, s.name, room, "")
+	}
+}
+
+// switchRoom moves conn into the named room, announcing the move in both
+// rooms and replaying the new room's history
+func switchRoom(hub *models.Hub, conn net.Conn, roomName string) {
+	room, previous, err := rooms.Join(hub, conn, roomName)
+	if errors.Is(err, rooms.ErrInvalidName) {
+		utils.Send(hub, conn, fmt.Sprintf("Invalid room name. Use up to %d letters, digits, '-' or '_'.\n", rooms.MaxNameLength))
+		return
+	} else if err != nil {
+		utils.Send(hub, conn, "Unable to join that room right now.\n")
+		return
+	}
+	if previous == room.Name {
+		utils.Send(hub, conn, fmt.Sprintf("You are already in #%s.\n", room.Name))
+		return
+	}
+
+	hub.Mu.Lock()
+	name := hub.Name(conn)
+	hub.Mu.Unlock()
+
+	leave := models.NewMessage(models.KindLeave, name, previous, "")
+	leave.Meta = map[string]string{"to": room.Name}
+	hub.Broadcast <- leave
+
+	utils.Send(hub, conn, fmt.Sprintf("You joined #%s.\n", room.Name))
+	if hub.Features.History {
+		utils.SendRecentHistory(hub, conn, room, hub.HistoryLines)
+	}
+
+	join := models.NewMessage(models.KindJoin, name, room.Name, "")
+	join.Meta = map[string]string{"from": previous}
+	hub.Broadcast <- join
+}
+
+// sendDirect delivers a private message and reports a missing recipient to the sender
+func sendDirect(hub *models.Hub, conn net.Conn, to string, text string) {
+	if err := utils.SendDirect(hub, conn, to, text); errors.Is(err, utils.ErrNoSuchUser) {
+		utils.Send(hub, conn, fmt.Sprintf("No user named %s is connected.\n", to))
+	}
+}
+
+// describe turns an error into a sentence for the client
+func describe(err error) string {
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:] + ".\n"
+}
+
+// historyPage is how many lines /history shows when no count is given
+const historyPage = 20
+
+// showHistory handles "/history [n] [before <timestamp>]" by paging back
+// through the history the current room keeps in memory
+func showHistory(hub *models.Hub, conn net.Conn, args string) {
+	usage := "Usage: /history [n] [before YYYY-MM-DD HH:MM:SS]\n"
+	n := historyPage
+	var before time.Time
+
+	hub.Mu.Lock()
+	prefs := hub.Prefs[conn]
+	hub.Mu.Unlock()
+	loc := prefs.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) > 0 && fields[0] != "before" {
+		count, err := strconv.Atoi(fields[0])
+		if err != nil || count < 1 {
+			utils.Send(hub, conn, usage)
+			return
+		}
+		n = count
+		fields = fields[1:]
+	}
+	if len(fields) > 0 {
+		if fields[0] != "before" || len(fields) < 2 {
+			utils.Send(hub, conn, usage)
+			return
+		}
+		stamp := strings.Join(fields[1:], " ")
+		t, err := time.ParseInLocation(history.TimeLayout, stamp, loc)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", stamp, loc)
+		}
+		if err != nil {
+			utils.Send(hub, conn, usage)
+			return
+		}
+		before = t
+	}
+
+	hub.Mu.Lock()
+	room := hub.Rooms[hub.ClientRoom[conn]]
+	hub.Mu.Unlock()
+	if room == nil {
+		return
+	}
+
+	lines := room.History.Before(before, n)
+	if len(lines) == 0 {
+		utils.Send(hub, conn, "[No older history]\n")
+		return
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(prefs.Render(line) + "\n")
+	}
+	utils.Send(hub, conn, b.String())
+}
+
+// timeFormats are the named layouts accepted by /set timefmt
+var timeFormats = map[string]string{
+	"default": history.TimeLayout,
+	"iso":     "2006-01-02T15:04:05Z07:00",
+	"time":    "15:04:05",
+	"12h":     "2006-01-02 03:04:05 PM",
+}
+
+// setPref handles "/set [timefmt <format> | tz <zone> | color <on|off>]".
+// Without arguments it shows the current settings.
+func setPref(hub *models.Hub, conn net.Conn, args string) {
+	key, value, _ := strings.Cut(args, " ")
+	value = strings.TrimSpace(value)
+
+	hub.Mu.Lock()
+	prefs := hub.Prefs[conn]
+	hub.Mu.Unlock()
+
+	switch {
+	case key == "":
+		layout := prefs.TimeFormat
+		if layout == "" {
+			layout = history.TimeLayout
+		}
+		zone := "local (" + time.Local.String() + ")"
+		if prefs.Location != nil {
+			zone = prefs.Location.String()
+		}
+		color := "off"
+		if prefs.Color {
+			color = "on"
+		}
+		utils.Send(hub, conn, fmt.Sprintf("timefmt: %s\ntz: %s\ncolor: %s\n", layout, zone, color))
+		return
+	case key == "timefmt" && value != "":
+		layout, ok := timeFormats[strings.ToLower(value)]
+		if !ok {
+			// A custom layout must contain at least one Go layout element
+			reference := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+			if reference.Format(value) == value {
+				utils.Send(hub, conn, "Unknown time format. Use default, iso, time, 12h or a Go layout such as 15:04.\n")
+				return
+			}
+			layout = value
+		}
+		prefs.TimeFormat = layout
+	case key == "tz" && value != "":
+		if strings.EqualFold(value, "local") {
+			prefs.Location = nil
+			break
+		}
+		loc, err := time.LoadLocation(value)
+		if err != nil {
+			utils.Send(hub, conn, fmt.Sprintf("Unknown timezone %s. Use a name such as UTC or Europe/Paris.\n", value))
+			return
+		}
+		prefs.Location = loc
+	case key == "color" && (strings.EqualFold(value, "on") || strings.EqualFold(value, "off")):
+		prefs.Color = strings.EqualFold(value, "on")
+	default:
+		utils.Send(hub, conn, "Usage: /set timefmt <default|iso|time|12h|layout>, /set tz <zone|local> or /set color <on|off>\n")
+		return
+	}
+
+	hub.Mu.Lock()
+	hub.Prefs[conn] = prefs
+	name := hub.Name(conn)
+	hub.Mu.Unlock()
+
+	if key == "color" {
+		if prefs.Color {
+			utils.Send(hub, conn, fmt.Sprintf("Color is on. You are %s, and *bold* and _italic_ now look %s and %s.\n", style.Name(name), style.Markup("*like this*"), style.Markup("_like this_")))
+		} else {
+			utils.Send(hub, conn, "Color is off.\n")
+		}
+		return
+	}
+	utils.Send(hub, conn, fmt.Sprintf("Timestamps now look like %s.\n", prefs.Stamp(time.Now())))
 }
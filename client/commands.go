@@ -0,0 +1,460 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"netcat/models"
+	"netcat/moderation"
+	"netcat/names"
+	"netcat/rooms"
+	"netcat/utils"
+)
+
+// session is the state of one connected client that commands read and change
+type session struct {
+	hub  *models.Hub
+	conn net.Conn
+
+	// name is the client's current name; account is the registered name
+	// it logged in to or registered, if any
+	name    string
+	account string
+
+	// renamed is when the client last changed its name
+	renamed time.Time
+
+	// quit ends the session once the command returns
+	quit bool
+}
+
+// role is who may run a command
+type role int
+
+const (
+	anyone role = iota
+	operator
+)
+
+// arg is one argument in the usage of a command
+type arg struct {
+	name     string
+	optional bool
+
+	// rest takes the remainder of the line, spaces included
+	rest bool
+}
+
+// command is a slash command the dispatcher knows about
+type command struct {
+	name    string
+	aliases []string
+	args    []arg
+	role    role
+	help    string
+
+	// feature reports whether the command is enabled on the server;
+	// disabled is the reply when it is not
+	feature  func(models.Features) bool
+	disabled string
+
+	// raw commands get their arguments as typed, before the line is
+	// cleaned, as passwords must be
+	raw bool
+
+	// chat marks commands whose text other users read, so repeating it
+	// counts against the flood limits; unlimited commands do not count
+	// at all
+	chat      bool
+	unlimited bool
+
+	run func(s *session, args string)
+}
+
+// commands maps every command name and alias to its command
+var commands = map[string]*command{}
+
+// register adds cmd to the registry under its name and aliases
+func register(cmd *command) {
+	for _, name := range append([]string{cmd.name}, cmd.aliases...) {
+		if _, taken := commands[name]; taken {
+			panic("client: command /" + name + " registered twice")
+		}
+		commands[name] = cmd
+	}
+}
+
+// usage returns how cmd is typed, such as "/msg <name> <message>"
+func (cmd *command) usage() string {
+	var b strings.Builder
+	b.WriteString("/" + cmd.name)
+	for _, a := range cmd.args {
+		if a.optional {
+			b.WriteString(" [" + a.name + "]")
+		} else {
+			b.WriteString(" <" + a.name + ">")
+		}
+	}
+	return b.String()
+}
+
+// accepts reports whether args has as many words as the usage of cmd allows
+func (cmd *command) accepts(args string) bool {
+	n := len(strings.Fields(args))
+	required := 0
+	for _, a := range cmd.args {
+		if !a.optional {
+			required++
+		}
+	}
+	if n < required {
+		return false
+	}
+	if len(cmd.args) > 0 && cmd.args[len(cmd.args)-1].rest {
+		return true
+	}
+	return n <= len(cmd.args)
+}
+
+// enabled reports whether cmd can be used on the hub
+func (cmd *command) enabled(hub *models.Hub) bool {
+	return cmd.feature == nil || cmd.feature(hub.Features)
+}
+
+// parseCommand splits a line starting with "/" into the command it names
+// and its arguments. The command is nil when no such command exists.
+func parseCommand(line string) (cmd *command, name, args string) {
+	name, args, _ = strings.Cut(strings.TrimPrefix(line, "/"), " ")
+	return commands[strings.ToLower(name)], name, strings.TrimSpace(args)
+}
+
+// dispatch runs the command typed as line, raw being the line before it
+// was cleaned. Unknown and disabled commands, commands the client may not
+// use and wrong arguments are reported back to the client.
+func (s *session) dispatch(line, raw string) {
+	cmd, name, args := parseCommand(line)
+	switch {
+	case cmd == nil:
+		utils.Send(s.hub, s.conn, fmt.Sprintf("Unknown command /%s. Type /help to see the commands.\n", name))
+		return
+	case !cmd.enabled(s.hub):
+		utils.Send(s.hub, s.conn, cmd.disabled)
+		return
+	case cmd.role == operator && !moderation.IsOperator(s.hub, s.conn):
+		utils.Send(s.hub, s.conn, describe(moderation.ErrNotOperator))
+		return
+	}
+
+	if cmd.raw {
+		_, args, _ = strings.Cut(strings.TrimSpace(raw), " ")
+		args = strings.TrimSpace(args)
+	}
+	if !cmd.accepts(args) {
+		utils.Send(s.hub, s.conn, "Usage: "+cmd.usage()+"\n")
+		return
+	}
+	cmd.run(s, args)
+}
+
+// help handles "/help [command]"
+func help(s *session, args string) {
+	if args != "" {
+		cmd, ok := commands[strings.ToLower(strings.TrimPrefix(args, "/"))]
+		if !ok || !cmd.enabled(s.hub) {
+			utils.Send(s.hub, s.conn, fmt.Sprintf("Unknown command /%s. Type /help to see the commands.\n", strings.TrimPrefix(args, "/")))
+			return
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Usage: %s\n", cmd.usage())
+		if len(cmd.aliases) > 0 {
+			fmt.Fprintf(&b, "Aliases: /%s\n", strings.Join(cmd.aliases, ", /"))
+		}
+		if cmd.role == operator {
+			b.WriteString("Operators only.\n")
+		}
+		b.WriteString(cmd.help + "\n")
+		utils.Send(s.hub, s.conn, b.String())
+		return
+	}
+
+	// Operator commands are only listed for operators
+	isOp := moderation.IsOperator(s.hub, s.conn)
+	var list []*command
+	width := 0
+	for name, cmd := range commands {
+		if name != cmd.name || !cmd.enabled(s.hub) || cmd.role == operator && !isOp {
+			continue
+		}
+		list = append(list, cmd)
+		width = max(width, len(cmd.usage()))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, cmd := range list {
+		fmt.Fprintf(&b, "  %-*s  %s\n", width, cmd.usage(), cmd.help)
+	}
+	b.WriteString("Type /help <command> for details. Start a message with // to send it with a single leading /.\n")
+	utils.Send(s.hub, s.conn, b.String())
+}
+
+func init() {
+	roomsOn := func(f models.Features) bool { return f.Rooms }
+	dmsOn := func(f models.Features) bool { return f.DirectMessages }
+	historyOn := func(f models.Features) bool { return f.History }
+	const (
+		roomsOff   = "Rooms are disabled on this server.\n"
+		dmsOff     = "Direct messages are disabled on this server.\n"
+		historyOff = "History is disabled on this server.\n"
+	)
+
+	register(&command{
+		name:      "quit",
+		aliases:   []string{"exit"},
+		help:      "Disconnect from the server.",
+		unlimited: true,
+		run:       func(s *session, _ string) { s.quit = true },
+	})
+	register(&command{
+		name: "help",
+		args: []arg{{name: "command", optional: true}},
+		help: "List the commands, or explain one.",
+		run:  help,
+	})
+	register(&command{
+		name:    "rename",
+		aliases: []string{"nick"},
+		args:    []arg{{name: "new_name"}},
+		help:    "Change your name.",
+		run:     rename,
+	})
+	register(&command{
+		name: "whowas",
+		args: []arg{{name: "name"}},
+		help: "Show the name changes of a user and what they are called now.",
+		run:  whowas,
+	})
+	register(&command{
+		name: "who",
+		help: "List the connected users and how long they have been idle.",
+		run:  who,
+	})
+	register(&command{
+		name: "whois",
+		args: []arg{{name: "name"}},
+		help: "Show when a user joined, where they are and whether they are away.",
+		run:  whois,
+	})
+	register(&command{
+		name: "away",
+		args: []arg{{name: "message", optional: true, rest: true}},
+		help: "Mark yourself as away; users who message or mention you are told.",
+		run:  away,
+	})
+	register(&command{
+		name: "back",
+		help: "Clear your away message.",
+		run:  back,
+	})
+	register(&command{
+		name:     "rooms",
+		help:     "List the rooms and how many users are in each.",
+		feature:  roomsOn,
+		disabled: roomsOff,
+		run: func(s *session, _ string) {
+			utils.Send(s.hub, s.conn, "Rooms:\n  "+strings.Join(rooms.List(s.hub, s.conn), "\n  ")+"\n")
+		},
+	})
+	register(&command{
+		name:     "join",
+		args:     []arg{{name: "room"}},
+		help:     "Switch to a room, creating it if it does not exist.",
+		feature:  roomsOn,
+		disabled: roomsOff,
+		run:      func(s *session, args string) { switchRoom(s.hub, s.conn, args) },
+	})
+	register(&command{
+		name:     "leave",
+		aliases:  []string{"part"},
+		help:     "Go back to the lobby.",
+		feature:  roomsOn,
+		disabled: roomsOff,
+		run: func(s *session, _ string) {
+			if rooms.Current(s.hub, s.conn) == models.Lobby {
+				utils.Send(s.hub, s.conn, "You are already in the lobby. Use /quit to disconnect.\n")
+				return
+			}
+			switchRoom(s.hub, s.conn, models.Lobby)
+		},
+	})
+	register(&command{
+		name:     "msg",
+		aliases:  []string{"m"},
+		args:     []arg{{name: "name"}, {name: "message", rest: true}},
+		help:     "Send a private message to one user.",
+		feature:  dmsOn,
+		disabled: dmsOff,
+		chat:     true,
+		run: func(s *session, args string) {
+			to, text, _ := strings.Cut(args, " ")
+			s.direct(to, strings.TrimSpace(text))
+		},
+	})
+	register(&command{
+		name:     "reply",
+		aliases:  []string{"r"},
+		args:     []arg{{name: "message", rest: true}},
+		help:     "Answer the last user who sent you a private message.",
+		feature:  dmsOn,
+		disabled: dmsOff,
+		chat:     true,
+		run: func(s *session, args string) {
+			s.hub.Mu.Lock()
+			target := s.hub.ReplyTo[s.conn]
+			s.hub.Mu.Unlock()
+
+			if target == "" {
+				utils.Send(s.hub, s.conn, "Nobody has sent you a direct message yet.\n")
+				return
+			}
+			s.direct(target, args)
+		},
+	})
+	register(&command{
+		name:     "history",
+		args:     []arg{{name: "n", optional: true}, {name: "before <time>", optional: true, rest: true}},
+		help:     "Show earlier lines of this room, optionally only those before YYYY-MM-DD [HH:MM:SS].",
+		feature:  historyOn,
+		disabled: historyOff,
+		run:      func(s *session, args string) { showHistory(s.hub, s.conn, args) },
+	})
+	register(&command{
+		name: "set",
+		args: []arg{{name: "timefmt|tz|color <value>", optional: true, rest: true}},
+		help: "Show your settings, or change how timestamps and names are shown.",
+		run:  func(s *session, args string) { setPref(s.hub, s.conn, args) },
+	})
+	register(&command{
+		name: "register",
+		args: []arg{{name: "password"}},
+		help: "Register your current name so only you can use it.",
+		raw:  true,
+		run:  registerName,
+	})
+	register(&command{
+		name: "passwd",
+		args: []arg{{name: "old password"}, {name: "new password"}},
+		help: "Change the password of your registered name.",
+		raw:  true,
+		run:  changePassword,
+	})
+	register(&command{
+		name: "op",
+		args: []arg{{name: "password"}},
+		help: "Become an operator.",
+		run:  func(s *session, args string) { moderate(s.hub, s.conn, "op", args) },
+	})
+	for _, cmd := range []*command{
+		{name: "kick", args: []arg{{name: "name"}, {name: "reason", optional: true, rest: true}}, help: "Disconnect a user."},
+		{name: "mute", args: []arg{{name: "name"}, {name: "duration", optional: true}}, help: "Stop a user from chatting, for a while (10m, 2h) or until unmuted."},
+		{name: "unmute", args: []arg{{name: "name"}}, help: "Let a muted user chat again."},
+		{name: "ban", args: []arg{{name: "name|ip"}, {name: "duration", optional: true}, {name: "reason", optional: true, rest: true}}, help: "Disconnect and refuse a name or address, for good or for a while."},
+		{name: "unban", args: []arg{{name: "name|ip"}}, help: "Lift a ban."},
+	} {
+		name := cmd.name
+		cmd.role = operator
+		cmd.run = func(s *session, args string) { moderate(s.hub, s.conn, name, args) }
+		register(cmd)
+	}
+}
+
+// direct sends a private message unless the client is muted
+func (s *session) direct(to, text string) {
+	if remaining, muted := moderation.MutedFor(s.hub, s.name); muted {
+		utils.Send(s.hub, s.conn, mutedNotice(remaining))
+		return
+	}
+	sendDirect(s.hub, s.conn, to, text)
+}
+
+// rename handles "/rename <new_name>". The change is announced in the
+// client's room, which also writes it to the room log, and remembered for
+// /whowas.
+func rename(s *session, newName string) {
+	if newName == s.name {
+		utils.Send(s.hub, s.conn, fmt.Sprintf("You are already called %s.\n", newName))
+		return
+	}
+	if wait := s.hub.RenameCooldown - time.Since(s.renamed); !s.renamed.IsZero() && wait > 0 {
+		utils.Send(s.hub, s.conn, fmt.Sprintf("You can change your name again in %s.\n", moderation.FormatDuration(wait.Round(time.Second))))
+		return
+	}
+	if _, muted := moderation.MutedFor(s.hub, s.name); muted {
+		utils.Send(s.hub, s.conn, "You cannot change your name while muted.\n")
+		return
+	}
+	if _, banned := s.hub.Bans.Name(newName); banned {
+		utils.Send(s.hub, s.conn, "That name is banned on this server.\n")
+		return
+	}
+	if s.hub.Accounts.Registered(newName) && !strings.EqualFold(newName, s.account) {
+		utils.Send(s.hub, s.conn, "That name is registered to someone else.\n")
+		return
+	}
+
+	oldName, err := names.Claim(s.hub, s.conn, newName)
+	if err != nil {
+		utils.Send(s.hub, s.conn, describe(err))
+		return
+	}
+
+	msg := models.NewMessage(models.KindRename, newName, rooms.Current(s.hub, s.conn), "")
+	msg.Meta = map[string]string{"old": oldName}
+	s.hub.Mu.Lock()
+	names.Record(s.hub, oldName, newName, msg.Time)
+	s.hub.Mu.Unlock()
+	s.hub.Broadcast <- msg
+	s.name = newName
+	s.renamed = msg.Time
+
+	// Render skips renames for the user who made them, so confirm it here
+	utils.Send(s.hub, s.conn, fmt.Sprintf("You are now known as %s.\n", newName))
+}
+
+// whowas handles "/whowas <name>"
+func whowas(s *session, name string) {
+	s.hub.Mu.Lock()
+	changes, current := names.History(s.hub, name)
+	_, spelled, online := names.Find(s.hub, current)
+	prefs := s.hub.Prefs[s.conn]
+	s.hub.Mu.Unlock()
+
+	var b strings.Builder
+	if len(changes) == 0 {
+		if online {
+			fmt.Fprintf(&b, "%s is online and has not changed name.\n", spelled)
+		} else {
+			fmt.Fprintf(&b, "No name changes of %s are known.\n", name)
+		}
+		utils.Send(s.hub, s.conn, b.String())
+		return
+	}
+
+	fmt.Fprintf(&b, "Name changes of %s:\n", name)
+	for _, r := range changes {
+		fmt.Fprintf(&b, "  [%s] %s changed their name to %s\n", prefs.Stamp(r.Time), r.Old, r.New)
+	}
+	switch {
+	case online && !strings.EqualFold(current, name):
+		fmt.Fprintf(&b, "%s is now known as %s, who is online.\n", name, spelled)
+	case online:
+		fmt.Fprintf(&b, "%s is online.\n", spelled)
+	default:
+		fmt.Fprintf(&b, "%s is not connected.\n", current)
+	}
+	utils.Send(s.hub, s.conn, b.String())
+}
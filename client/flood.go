@@ -0,0 +1,42 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"netcat/flood"
+	"netcat/models"
+	"netcat/moderation"
+	"netcat/utils"
+)
+
+// floodReasons explains each flood rule to the client that broke it
+var floodReasons = map[flood.Reason]string{
+	flood.TooFast:  "You are sending messages too quickly.",
+	flood.Repeated: "You already sent that message.",
+}
+
+// checkFlood applies the flood limits to a line from name. text is what
+// other users read of the line, or "" for commands that only the sender
+// sees; only such text counts as a repeat. It reports whether the line may
+// be handled and whether the client has to be disconnected.
+func checkFlood(hub *models.Hub, conn net.Conn, guard *flood.Guard, name, text string) (ok, quit bool) {
+	verdict := guard.Check(time.Now(), text)
+	reason := floodReasons[verdict.Reason]
+
+	switch verdict.Action {
+	case flood.Allow:
+		return true, false
+	case flood.Warn:
+		utils.Send(hub, conn, reason+" Please slow down.\n")
+	case flood.Throttle:
+		utils.Send(hub, conn, fmt.Sprintf("%s Your messages will be ignored for %s.\n", reason, moderation.FormatDuration(verdict.For)))
+	case flood.Mute:
+		moderation.Silence(hub, name, verdict.For, "flooding")
+	case flood.Disconnect:
+		utils.Send(hub, conn, reason+" You have been disconnected for flooding.\n")
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"netcat/models"
+	"netcat/moderation"
+	"netcat/utils"
+)
+
+// moderate runs the operator command called command and tells conn how it
+// went. Durations use Go syntax such as 10m or 24h.
+func moderate(hub *models.Hub, conn net.Conn, command, args string) {
+	target, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+
+	var err error
+	switch command {
+	case "op":
+		if err = moderation.Op(hub, conn, args); err == nil {
+			utils.Send(hub, conn, "You are now an operator.\n")
+		}
+	case "kick":
+		err = moderation.Kick(hub, conn, target, rest)
+	case "mute":
+		var d time.Duration
+		if rest != "" {
+			if d, err = time.ParseDuration(rest); err != nil || d <= 0 {
+				utils.Send(hub, conn, "Usage: "+commands[command].usage()+"\n")
+				return
+			}
+		}
+		err = moderation.Mute(hub, conn, target, d)
+	case "unmute":
+		err = moderation.Unmute(hub, conn, target)
+	case "ban":
+		// The duration is optional, so a reason may follow the target directly
+		var d time.Duration
+		first, reason, _ := strings.Cut(rest, " ")
+		if parsed, perr := time.ParseDuration(first); perr == nil && parsed > 0 {
+			d = parsed
+			rest = strings.TrimSpace(reason)
+		}
+		err = moderation.Ban(hub, conn, target, d, rest)
+	case "unban":
+		err = moderation.Unban(hub, conn, target)
+	}
+
+	if errors.Is(err, utils.ErrNoSuchUser) {
+		utils.Send(hub, conn, fmt.Sprintf("No user named %s is connected.\n", target))
+	} else if err != nil {
+		utils.Send(hub, conn, describe(err))
+	}
+}
+
+// mutedNotice tells a muted user why their message was not sent
+func mutedNotice(remaining time.Duration) string {
+	if remaining == 0 {
+		return "You are muted.\n"
+	}
+	return fmt.Sprintf("You are muted for another %s.\n", moderation.FormatDuration(remaining.Round(time.Second)))
+}
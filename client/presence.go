@@ -0,0 +1,110 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"netcat/moderation"
+	"netcat/presence"
+	"netcat/sessions"
+	"netcat/utils"
+)
+
+// since formats how long ago t was, to the second
+func since(now, t time.Time) string {
+	return moderation.FormatDuration(now.Sub(t).Truncate(time.Second))
+}
+
+// who handles "/who", listing the connected users with their room and how
+// long they have been idle
+func who(s *session, _ string) {
+	list := presence.List(s.hub)
+	now := time.Now()
+
+	width := 0
+	for _, c := range list {
+		width = max(width, len(c.Name))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Users online (%d):\n", len(list))
+	for _, c := range list {
+		fmt.Fprintf(&b, "  %-*s", width, c.Name)
+		if s.hub.Features.Rooms {
+			fmt.Fprintf(&b, "  #%s", c.Room)
+		}
+		fmt.Fprintf(&b, "  idle %s", since(now, c.Active))
+		if c.Away != "" {
+			fmt.Fprintf(&b, "  (away: %s)", c.Away)
+		}
+		if c.Operator {
+			b.WriteString("  (operator)")
+		}
+		b.WriteString("\n")
+	}
+	utils.Send(s.hub, s.conn, b.String())
+}
+
+// away handles "/away [message]"
+func away(s *session, message string) {
+	if message == "" {
+		message = presence.DefaultAwayMessage
+	}
+	presence.SetAway(s.hub, s.conn, message)
+	utils.Send(s.hub, s.conn, fmt.Sprintf("You are marked as away (%s). Type /back when you return.\n", message))
+}
+
+// back handles "/back"
+func back(s *session, _ string) {
+	if !presence.Back(s.hub, s.conn) {
+		utils.Send(s.hub, s.conn, "You are not marked as away.\n")
+		return
+	}
+	utils.Send(s.hub, s.conn, "Welcome back. You are no longer marked as away.\n")
+}
+
+// whois handles "/whois <name>". Only operators see the address a user
+// connects from.
+func whois(s *session, name string) {
+	c, ok := presence.Lookup(s.hub, name)
+
+	s.hub.Mu.Lock()
+	prefs := s.hub.Prefs[s.conn]
+	_, isOperator := s.hub.Operators[s.conn]
+	waiting, detached := sessions.Find(s.hub, name)
+	var dropped time.Time
+	if detached {
+		name, dropped = waiting.Name, waiting.Since
+	}
+	s.hub.Mu.Unlock()
+
+	now := time.Now()
+	if !ok {
+		if detached {
+			utils.Send(s.hub, s.conn, fmt.Sprintf("%s lost their connection %s ago and may still come back.\n", name, since(now, dropped)))
+		} else {
+			utils.Send(s.hub, s.conn, fmt.Sprintf("No user named %s is connected.\n", name))
+		}
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", c.Name)
+	fmt.Fprintf(&b, "  Joined:    %s (%s ago)\n", prefs.Stamp(c.Joined), since(now, c.Joined))
+	fmt.Fprintf(&b, "  Connected: %s\n", since(now, c.Connected))
+	if s.hub.Features.Rooms {
+		fmt.Fprintf(&b, "  Room:      #%s\n", c.Room)
+	}
+	fmt.Fprintf(&b, "  Idle:      %s\n", since(now, c.Active))
+	if c.Away != "" {
+		fmt.Fprintf(&b, "  Away:      %s\n", c.Away)
+	}
+	if c.Operator {
+		b.WriteString("  Operator\n")
+	}
+	if isOperator {
+		fmt.Fprintf(&b, "  Address:   %s\n", c.Addr)
+	}
+	utils.Send(s.hub, s.conn, b.String())
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"netcat/models"
+	"netcat/moderation"
+	"netcat/sessions"
+	"netcat/utils"
+)
+
+// resume handles "/resume <token>" at the name prompt. It gives conn the
+// session waiting under token and replays what was said while it was
+// away, ahead of anything new. It reports false if the client has to try
+// again or, when quit is set, be disconnected.
+func resume(hub *models.Hub, conn net.Conn, token string) (session *models.Session, ok, quit bool) {
+	hub.Mu.Lock()
+
+	// A name banned while its client was away cannot come back; its
+	// session runs out as usual
+	if waiting, found := hub.Detached[token]; found {
+		if entry, banned := hub.Bans.Name(waiting.Name); banned {
+			hub.Mu.Unlock()
+			utils.Send(hub, conn, moderation.BanNotice(entry))
+			return nil, false, true
+		}
+	}
+
+	session, err := sessions.Resume(hub, conn, token)
+	if err != nil {
+		hub.Mu.Unlock()
+		utils.Send(hub, conn, describe(err))
+		return nil, false, false
+	}
+
+	prefs := hub.Prefs[conn]
+	away := moderation.FormatDuration(time.Since(session.Since).Round(time.Second))
+	utils.Deliver(hub, conn, fmt.Sprintf("Welcome back, %s. You were away for %s and are in #%s.\n", session.Name, away, session.Room))
+	if session.Dropped > 0 {
+		utils.Deliver(hub, conn, fmt.Sprintf("[%d older messages were not kept]\n", session.Dropped))
+	}
+	if len(session.Missed) == 0 {
+		utils.Deliver(hub, conn, "[You missed nothing]\n")
+	}
+	for _, msg := range session.Missed {
+		if text := msg.Render(session.Name, prefs); text != "" {
+			utils.Deliver(hub, conn, text)
+		}
+	}
+	hub.Mu.Unlock()
+	return session, true, false
+}
+
+// issueToken gives conn a resume token and tells the client how to use it
+func issueToken(hub *models.Hub, conn net.Conn) {
+	token := sessions.Issue(hub, conn)
+	if token == "" {
+		return
+	}
+
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+	grace := moderation.FormatDuration(hub.ResumeGrace)
+	utils.Deliver(hub, conn, fmt.Sprintf("[Your resume token is %s. If your connection drops, reconnect within %s and enter /resume %s at the name prompt.]\n", token, grace, token))
+}
@@ -0,0 +1,608 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"netcat/certs"
+	"netcat/chatlog"
+	"netcat/flood"
+	"netcat/history"
+	"netcat/input"
+	"netcat/logfile"
+	"netcat/models"
+	"netcat/outbox"
+	"netcat/server"
+)
+
+// DefaultPort is used when neither the config file nor the flags set a port
+const DefaultPort = 9060
+
+// DefaultBanner is the banner shown when none is configured. Unlike a
+// banner that was set, it may be missing, and clients then see none.
+const DefaultBanner = "logo.txt"
+
+// Duration is a time.Duration read from JSON as a string such as "5s"
+type Duration time.Duration
+
+// MarshalJSON writes the duration in time.Duration notation
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts "1m30s" style strings
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Features mirrors models.Features in the config file
+type Features struct {
+	Rooms          bool `json:"rooms"`
+	DirectMessages bool `json:"direct_messages"`
+	History        bool `json:"history"`
+}
+
+// Limits mirrors logfile.Retention in the config file
+type Limits struct {
+	Lines int      `json:"lines"`
+	Age   Duration `json:"age"`
+	Bytes int64    `json:"bytes"`
+}
+
+// Flood mirrors flood.Config in the config file
+type Flood struct {
+	Burst        int      `json:"burst"`
+	Refill       Duration `json:"refill"`
+	HostBurst    int      `json:"host_burst"`
+	HostRefill   Duration `json:"host_refill"`
+	Repeats      int      `json:"repeats"`
+	RepeatWindow Duration `json:"repeat_window"`
+	Throttle     Duration `json:"throttle"`
+	Mute         Duration `json:"mute"`
+	Forgive      Duration `json:"forgive"`
+}
+
+// NameRules mirrors models.NameRules in the config file
+type NameRules struct {
+	MinLength          int      `json:"min_length"`
+	MaxLength          int      `json:"max_length"`
+	AllowedPunctuation string   `json:"allowed_punctuation"`
+	Reserved           []string `json:"reserved"`
+}
+
+// Config is everything the server can be configured with
+type Config struct {
+	Address        string    `json:"address"`
+	Port           int       `json:"port"`
+	Plain          bool      `json:"plain"`
+	TLSPort        int       `json:"tls_port"`
+	TLSCert        string    `json:"tls_cert"`
+	TLSKey         string    `json:"tls_key"`
+	LogDir         string    `json:"log_dir"`
+	BannerFile     string    `json:"banner_file"`
+	MaxClients     int       `json:"max_clients"`
+	WaitQueue      int       `json:"wait_queue"`
+	AdminHosts     []string  `json:"admin_hosts"`
+	OpHosts        []string  `json:"op_hosts"`
+	OpPassword     string    `json:"op_password"`
+	BanFile        string    `json:"ban_file"`
+	AccountFile    string    `json:"account_file"`
+	HistoryLines   int       `json:"history_lines"`
+	HistoryBuffer  int       `json:"history_buffer"`
+	LogFormat      string    `json:"log_format"`
+	LogRotateBytes int64     `json:"log_rotate_bytes"`
+	HistoryMax     Limits    `json:"history_max"`
+	ShutdownGrace  Duration  `json:"shutdown_grace"`
+	ShutdownNotice string    `json:"shutdown_notice"`
+	CloseTimeout   Duration  `json:"close_timeout"`
+	WriteTimeout   Duration  `json:"write_timeout"`
+	OutboxSize     int       `json:"outbox_size"`
+	OverflowPolicy string    `json:"overflow_policy"`
+	MaxLineBytes   int       `json:"max_line_bytes"`
+	OversizePolicy string    `json:"oversize_policy"`
+	NameTimeout    Duration  `json:"name_timeout"`
+	ResumeGrace    Duration  `json:"resume_grace"`
+	RenameCooldown Duration  `json:"rename_cooldown"`
+	AutoAway       Duration  `json:"auto_away"`
+	NameRules      NameRules `json:"name_rules"`
+	Flood          Flood     `json:"flood"`
+	Features       Features  `json:"features"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() Config {
+	return Config{
+		Port:           DefaultPort,
+		Plain:          true,
+		LogDir:         "logs",
+		BanFile:        "bans.json",
+		AccountFile:    "accounts.json",
+		BannerFile:     DefaultBanner,
+		MaxClients:     server.DefaultMaxClients,
+		HistoryLines:   models.DefaultHistoryLines,
+		HistoryBuffer:  history.DefaultCapacity,
+		LogFormat:      chatlog.Text.String(),
+		LogRotateBytes: 10 << 20,
+		ShutdownGrace:  Duration(server.DefaultShutdownGrace),
+		ShutdownNotice: server.DefaultShutdownNotice,
+		CloseTimeout:   Duration(server.DefaultCloseTimeout),
+		WriteTimeout:   Duration(outbox.DefaultConfig.WriteTimeout),
+		OutboxSize:     outbox.DefaultConfig.Size,
+		OverflowPolicy: outbox.DefaultConfig.Policy.String(),
+		MaxLineBytes:   input.DefaultMaxLine,
+		OversizePolicy: input.Reject.String(),
+		NameTimeout:    Duration(models.DefaultNameTimeout),
+		ResumeGrace:    Duration(models.DefaultResumeGrace),
+		RenameCooldown: Duration(models.DefaultRenameCooldown),
+		AutoAway:       Duration(models.DefaultAutoAway),
+		Flood: Flood{
+			Burst:        flood.DefaultConfig.Burst,
+			Refill:       Duration(flood.DefaultConfig.Refill),
+			HostBurst:    flood.DefaultConfig.HostBurst,
+			HostRefill:   Duration(flood.DefaultConfig.HostRefill),
+			Repeats:      flood.DefaultConfig.Repeats,
+			RepeatWindow: Duration(flood.DefaultConfig.RepeatWindow),
+			Throttle:     Duration(flood.DefaultConfig.Throttle),
+			Mute:         Duration(flood.DefaultConfig.Mute),
+			Forgive:      Duration(flood.DefaultConfig.Forgive),
+		},
+		NameRules: NameRules{
+			MinLength:          models.DefaultNameRules.MinLength,
+			MaxLength:          models.DefaultNameRules.MaxLength,
+			AllowedPunctuation: models.DefaultNameRules.AllowedPunctuation,
+			Reserved:           append([]string(nil), models.DefaultNameRules.Reserved...),
+		},
+		Features: Features{
+			Rooms:          models.DefaultFeatures.Rooms,
+			DirectMessages: models.DefaultFeatures.DirectMessages,
+			History:        models.DefaultFeatures.History,
+		},
+	}
+}
+
+// Load reads a JSON config file on top of the defaults
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse builds the configuration from command line arguments: defaults,
+// then the file given with -config, then any flags set explicitly. A single
+// positional argument is accepted as the port for backwards compatibility.
+func Parse(args []string, output io.Writer) (Config, error) {
+	defaults := Default()
+	var configPath string
+	var cfg Config
+
+	fs := flag.NewFlagSet("TCPChat", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&configPath, "config", "", "path to a JSON config file")
+	fs.StringVar(&cfg.Address, "addr", defaults.Address, "address to bind to (empty for all interfaces)")
+	fs.IntVar(&cfg.Port, "port", defaults.Port, "port to listen on")
+	fs.BoolVar(&cfg.Plain, "plain", defaults.Plain, "accept unencrypted connections on -port")
+	fs.IntVar(&cfg.TLSPort, "tls-port", defaults.TLSPort, "port to accept TLS connections on (0 for no TLS)")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", defaults.TLSCert, "PEM certificate for the TLS listener")
+	fs.StringVar(&cfg.TLSKey, "tls-key", defaults.TLSKey, "PEM private key for the TLS listener")
+	fs.StringVar(&cfg.LogDir, "log-dir", defaults.LogDir, "directory for chat logs")
+	fs.StringVar(&cfg.BannerFile, "banner", defaults.BannerFile, "file shown to clients before the name prompt")
+	fs.IntVar(&cfg.MaxClients, "max-clients", defaults.MaxClients, "maximum number of connected clients")
+	fs.IntVar(&cfg.WaitQueue, "wait-queue", defaults.WaitQueue, "connections allowed to wait for a free slot")
+	fs.StringVar(&cfg.BanFile, "ban-file", defaults.BanFile, "file the ban list is kept in (empty to keep bans in memory)")
+	fs.StringVar(&cfg.AccountFile, "account-file", defaults.AccountFile, "file registered accounts are kept in (empty to keep them in memory)")
+	fs.IntVar(&cfg.HistoryLines, "history", defaults.HistoryLines, "lines of history sent to joining clients (0 for all)")
+	fs.IntVar(&cfg.HistoryBuffer, "history-buffer", defaults.HistoryBuffer, "lines of history kept in memory per room")
+	fs.StringVar(&cfg.LogFormat, "log-format", defaults.LogFormat, "chat log format: text or json")
+	fs.Int64Var(&cfg.LogRotateBytes, "log-rotate-bytes", defaults.LogRotateBytes, "start a new log segment past this size (0 to never rotate)")
+	fs.IntVar(&cfg.HistoryMax.Lines, "history-max-lines", defaults.HistoryMax.Lines, "lines of history kept across log segments (0 for no limit)")
+	fs.DurationVar((*time.Duration)(&cfg.HistoryMax.Age), "history-max-age", time.Duration(defaults.HistoryMax.Age), "delete log segments older than this (0 for no limit)")
+	fs.Int64Var(&cfg.HistoryMax.Bytes, "history-max-bytes", defaults.HistoryMax.Bytes, "bytes of history kept across log segments (0 for no limit)")
+	fs.DurationVar((*time.Duration)(&cfg.ShutdownGrace), "shutdown-grace", time.Duration(defaults.ShutdownGrace), "how long clients are warned before shutdown")
+	fs.StringVar(&cfg.ShutdownNotice, "shutdown-notice", defaults.ShutdownNotice, "message broadcast when the server shuts down; %d is replaced by the grace period in seconds")
+	fs.DurationVar((*time.Duration)(&cfg.CloseTimeout), "close-timeout", time.Duration(defaults.CloseTimeout), "bound on final writes during shutdown")
+	fs.DurationVar((*time.Duration)(&cfg.WriteTimeout), "write-timeout", time.Duration(defaults.WriteTimeout), "disconnect clients that stop reading for this long")
+	fs.IntVar(&cfg.OutboxSize, "outbox-size", defaults.OutboxSize, "messages queued for a slow client")
+	fs.StringVar(&cfg.OverflowPolicy, "overflow", defaults.OverflowPolicy, "full queue policy: drop-oldest, drop-newest or disconnect")
+	fs.IntVar(&cfg.MaxLineBytes, "max-line", defaults.MaxLineBytes, "longest line a client may send, in bytes")
+	fs.StringVar(&cfg.OversizePolicy, "oversize", defaults.OversizePolicy, "longer lines are: reject or truncate")
+	fs.DurationVar((*time.Duration)(&cfg.NameTimeout), "name-timeout", time.Duration(defaults.NameTimeout), "close connections that pick no name within this time (0 to wait forever)")
+	fs.IntVar(&cfg.NameRules.MinLength, "name-min", defaults.NameRules.MinLength, "shortest username allowed, in characters")
+	fs.IntVar(&cfg.NameRules.MaxLength, "name-max", defaults.NameRules.MaxLength, "longest username allowed, in characters")
+	fs.StringVar(&cfg.NameRules.AllowedPunctuation, "name-punctuation", defaults.NameRules.AllowedPunctuation, "characters besides letters and digits allowed in usernames")
+	fs.DurationVar((*time.Duration)(&cfg.RenameCooldown), "rename-cooldown", time.Duration(defaults.RenameCooldown), "how long users wait between renames (0 for no wait)")
+	fs.DurationVar((*time.Duration)(&cfg.AutoAway), "auto-away", time.Duration(defaults.AutoAway), "how long a user stays quiet before being marked as away (0 to turn it off)")
+	fs.DurationVar((*time.Duration)(&cfg.ResumeGrace), "resume-grace", time.Duration(defaults.ResumeGrace), "how long a dropped client can resume its session (0 to turn resuming off)")
+	fs.IntVar(&cfg.Flood.Burst, "flood-burst", defaults.Flood.Burst, "lines a client may send at once (0 for no limit)")
+	fs.DurationVar((*time.Duration)(&cfg.Flood.Refill), "flood-refill", time.Duration(defaults.Flood.Refill), "time a client waits to earn one more line")
+	fs.IntVar(&cfg.Flood.HostBurst, "flood-host-burst", defaults.Flood.HostBurst, "lines all clients from one address may send at once (0 for no limit)")
+	fs.DurationVar((*time.Duration)(&cfg.Flood.HostRefill), "flood-host-refill", time.Duration(defaults.Flood.HostRefill), "time an address waits to earn one more line")
+	fs.IntVar(&cfg.Flood.Repeats, "flood-repeats", defaults.Flood.Repeats, "times in a row a client may send the same message (0 for no limit)")
+	fs.BoolVar(&cfg.Features.Rooms, "rooms", defaults.Features.Rooms, "enable chat rooms")
+	fs.BoolVar(&cfg.Features.DirectMessages, "dm", defaults.Features.DirectMessages, "enable direct messages")
+	fs.BoolVar(&cfg.Features.History, "replay-history", defaults.Features.History, "send chat history to joining clients")
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	positional := fs.Args()
+	if len(positional) > 1 {
+		return Config{}, errors.New("[USAGE]: ./TCPChat $port")
+	}
+
+	result := defaults
+	if configPath != "" {
+		loaded, err := Load(configPath)
+		if err != nil {
+			return Config{}, err
+		}
+		result = loaded
+	}
+
+	// Flags given on the command line win over the config file
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			result.Address = cfg.Address
+		case "port":
+			result.Port = cfg.Port
+		case "plain":
+			result.Plain = cfg.Plain
+		case "tls-port":
+			result.TLSPort = cfg.TLSPort
+		case "tls-cert":
+			result.TLSCert = cfg.TLSCert
+		case "tls-key":
+			result.TLSKey = cfg.TLSKey
+		case "log-dir":
+			result.LogDir = cfg.LogDir
+		case "banner":
+			result.BannerFile = cfg.BannerFile
+		case "max-clients":
+			result.MaxClients = cfg.MaxClients
+		case "wait-queue":
+			result.WaitQueue = cfg.WaitQueue
+		case "ban-file":
+			result.BanFile = cfg.BanFile
+		case "account-file":
+			result.AccountFile = cfg.AccountFile
+		case "history":
+			result.HistoryLines = cfg.HistoryLines
+		case "history-buffer":
+			result.HistoryBuffer = cfg.HistoryBuffer
+		case "log-format":
+			result.LogFormat = cfg.LogFormat
+		case "log-rotate-bytes":
+			result.LogRotateBytes = cfg.LogRotateBytes
+		case "history-max-lines":
+			result.HistoryMax.Lines = cfg.HistoryMax.Lines
+		case "history-max-age":
+			result.HistoryMax.Age = cfg.HistoryMax.Age
+		case "history-max-bytes":
+			result.HistoryMax.Bytes = cfg.HistoryMax.Bytes
+		case "shutdown-grace":
+			result.ShutdownGrace = cfg.ShutdownGrace
+		case "shutdown-notice":
+			result.ShutdownNotice = cfg.ShutdownNotice
+		case "close-timeout":
+			result.CloseTimeout = cfg.CloseTimeout
+		case "write-timeout":
+			result.WriteTimeout = cfg.WriteTimeout
+		case "outbox-size":
+			result.OutboxSize = cfg.OutboxSize
+		case "overflow":
+			result.OverflowPolicy = cfg.OverflowPolicy
+		case "max-line":
+			result.MaxLineBytes = cfg.MaxLineBytes
+		case "oversize":
+			result.OversizePolicy = cfg.OversizePolicy
+		case "name-timeout":
+			result.NameTimeout = cfg.NameTimeout
+		case "name-min":
+			result.NameRules.MinLength = cfg.NameRules.MinLength
+		case "name-max":
+			result.NameRules.MaxLength = cfg.NameRules.MaxLength
+		case "name-punctuation":
+			result.NameRules.AllowedPunctuation = cfg.NameRules.AllowedPunctuation
+		case "rename-cooldown":
+			result.RenameCooldown = cfg.RenameCooldown
+		case "auto-away":
+			result.AutoAway = cfg.AutoAway
+		case "resume-grace":
+			result.ResumeGrace = cfg.ResumeGrace
+		case "flood-burst":
+			result.Flood.Burst = cfg.Flood.Burst
+		case "flood-refill":
+			result.Flood.Refill = cfg.Flood.Refill
+		case "flood-host-burst":
+			result.Flood.HostBurst = cfg.Flood.HostBurst
+		case "flood-host-refill":
+			result.Flood.HostRefill = cfg.Flood.HostRefill
+		case "flood-repeats":
+			result.Flood.Repeats = cfg.Flood.Repeats
+		case "rooms":
+			result.Features.Rooms = cfg.Features.Rooms
+		case "dm":
+			result.Features.DirectMessages = cfg.Features.DirectMessages
+		case "replay-history":
+			result.Features.History = cfg.Features.History
+		}
+	})
+
+	if len(positional) == 1 {
+		port, err := strconv.Atoi(positional[0])
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid port %q", positional[0])
+		}
+		result.Port = port
+	}
+
+	return result, result.Validate()
+}
+
+// Validate reports every problem with the configuration at once
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Address != "" && net.ParseIP(c.Address) == nil {
+		if _, err := net.LookupHost(c.Address); err != nil {
+			errs = append(errs, fmt.Errorf("address %q is not an IP address or known host", c.Address))
+		}
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range 0-65535", c.Port))
+	}
+	if c.TLSPort < 0 || c.TLSPort > 65535 {
+		errs = append(errs, fmt.Errorf("tls_port %d is out of range 0-65535", c.TLSPort))
+	}
+	if c.TLSPort != 0 || c.TLSCert != "" || c.TLSKey != "" {
+		if c.TLSPort == 0 || c.TLSCert == "" || c.TLSKey == "" {
+			errs = append(errs, errors.New("tls_port, tls_cert and tls_key must be set together"))
+		} else if _, err := c.TLSConfig(); err != nil {
+			errs = append(errs, fmt.Errorf("tls_cert/tls_key: %w", err))
+		}
+		if c.Plain && c.TLSPort == c.Port {
+			errs = append(errs, fmt.Errorf("tls_port must differ from port %d", c.Port))
+		}
+	}
+	if !c.Plain && c.TLSPort == 0 {
+		errs = append(errs, errors.New("plain is off and no tls_port is set, so there is nothing to listen on"))
+	}
+	if c.LogDir == "" {
+		errs = append(errs, errors.New("log_dir must not be empty"))
+	}
+	if c.BannerFile != "" && c.BannerFile != DefaultBanner {
+		if _, err := os.Stat(c.BannerFile); err != nil {
+			errs = append(errs, fmt.Errorf("banner_file: %w", err))
+		}
+	}
+	if c.MaxClients < 1 {
+		errs = append(errs, fmt.Errorf("max_clients must be at least 1, got %d", c.MaxClients))
+	}
+	if c.WaitQueue < 0 {
+		errs = append(errs, fmt.Errorf("wait_queue must not be negative, got %d", c.WaitQueue))
+	}
+	for _, host := range c.AdminHosts {
+		if net.ParseIP(host) == nil {
+			errs = append(errs, fmt.Errorf("admin_hosts: %q is not an IP address", host))
+		}
+	}
+	for _, host := range c.OpHosts {
+		if net.ParseIP(host) == nil {
+			errs = append(errs, fmt.Errorf("op_hosts: %q is not an IP address", host))
+		}
+	}
+	if c.HistoryLines < 0 {
+		errs = append(errs, fmt.Errorf("history_lines must not be negative, got %d", c.HistoryLines))
+	}
+	if c.HistoryBuffer < 1 {
+		errs = append(errs, fmt.Errorf("history_buffer must be at least 1, got %d", c.HistoryBuffer))
+	}
+	if _, err := chatlog.ParseFormat(c.LogFormat); err != nil {
+		errs = append(errs, fmt.Errorf("log_format: %w", err))
+	}
+	if c.LogRotateBytes < 0 {
+		errs = append(errs, fmt.Errorf("log_rotate_bytes must not be negative, got %d", c.LogRotateBytes))
+	}
+	if c.HistoryMax.Lines < 0 || c.HistoryMax.Age < 0 || c.HistoryMax.Bytes < 0 {
+		errs = append(errs, errors.New("history_max limits must not be negative"))
+	}
+	if c.ShutdownGrace < 0 || c.CloseTimeout < 0 || c.WriteTimeout < 0 {
+		errs = append(errs, errors.New("timeouts must not be negative"))
+	}
+	if strings.TrimSpace(c.ShutdownNotice) == "" {
+		errs = append(errs, errors.New("shutdown_notice must not be empty"))
+	} else if strings.Contains(fmt.Sprintf(c.ShutdownNotice, 0), "%!") {
+		errs = append(errs, fmt.Errorf("shutdown_notice %q may only use %%d, once, for the grace period in seconds", c.ShutdownNotice))
+	}
+	if c.OutboxSize < 1 {
+		errs = append(errs, fmt.Errorf("outbox_size must be at least 1, got %d", c.OutboxSize))
+	}
+	if _, err := c.overflowPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxLineBytes < 1 {
+		errs = append(errs, fmt.Errorf("max_line_bytes must be at least 1, got %d", c.MaxLineBytes))
+	}
+	if _, err := c.oversizePolicy(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.NameTimeout < 0 {
+		errs = append(errs, errors.New("name_timeout must not be negative"))
+	}
+	if c.NameRules.MinLength < 1 {
+		errs = append(errs, fmt.Errorf("name_rules min_length must be at least 1, got %d", c.NameRules.MinLength))
+	}
+	if c.NameRules.MaxLength < c.NameRules.MinLength {
+		errs = append(errs, fmt.Errorf("name_rules max_length %d must not be below min_length %d", c.NameRules.MaxLength, c.NameRules.MinLength))
+	}
+	if strings.IndexFunc(c.NameRules.AllowedPunctuation, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		errs = append(errs, errors.New("name_rules allowed_punctuation must not contain spaces or control characters"))
+	}
+	for _, name := range c.NameRules.Reserved {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, errors.New("name_rules reserved names must not be empty"))
+			break
+		}
+	}
+	if c.RenameCooldown < 0 {
+		errs = append(errs, errors.New("rename_cooldown must not be negative"))
+	}
+	if c.AutoAway < 0 {
+		errs = append(errs, errors.New("auto_away must not be negative"))
+	}
+	if c.ResumeGrace < 0 {
+		errs = append(errs, errors.New("resume_grace must not be negative"))
+	}
+	if c.Flood.Burst < 0 || c.Flood.HostBurst < 0 || c.Flood.Repeats < 0 {
+		errs = append(errs, errors.New("flood limits must not be negative"))
+	}
+	if c.Flood.Burst > 0 && c.Flood.Refill <= 0 || c.Flood.HostBurst > 0 && c.Flood.HostRefill <= 0 {
+		errs = append(errs, errors.New("flood refill times must be positive"))
+	}
+	if c.Flood.RepeatWindow < 0 || c.Flood.Throttle < 0 || c.Flood.Forgive < 0 {
+		errs = append(errs, errors.New("flood durations must not be negative"))
+	}
+	if c.Flood.Mute <= 0 {
+		errs = append(errs, fmt.Errorf("flood mute must be positive, got %v", time.Duration(c.Flood.Mute)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Addr returns the address to listen on
+func (c Config) Addr() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.Port))
+}
+
+// TLSAddr returns the address to accept TLS connections on
+func (c Config) TLSAddr() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.TLSPort))
+}
+
+// TLSConfig loads the certificate and key of the TLS listener. It returns
+// nil when TLS is off.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if c.TLSPort == 0 {
+		return nil, nil
+	}
+	return certs.Load(c.TLSCert, c.TLSKey)
+}
+
+// Options turns the configuration into server options
+func (c Config) Options() []server.Option {
+	policy, _ := c.overflowPolicy()
+	oversize, _ := c.oversizePolicy()
+	format, _ := chatlog.ParseFormat(c.LogFormat)
+
+	opts := []server.Option{
+		server.WithLogDir(c.LogDir),
+		// The logs keep the plain port's name even when TLS starts first
+		server.WithLogName(strconv.Itoa(c.Port)),
+		server.WithBanner(c.BannerFile),
+		server.WithMaxClients(c.MaxClients),
+		server.WithWaitQueue(c.WaitQueue),
+		server.WithHistoryLines(c.HistoryLines),
+		server.WithHistoryBuffer(c.HistoryBuffer),
+		server.WithLogFormat(format),
+		server.WithBanFile(c.BanFile),
+		server.WithAccountFile(c.AccountFile),
+		server.WithOpHosts(c.OpHosts...),
+		server.WithOpPassword(c.OpPassword),
+		server.WithLogRotation(logfile.Policy{
+			RotateBytes: c.LogRotateBytes,
+			Retention: logfile.Retention{
+				MaxLines: c.HistoryMax.Lines,
+				MaxAge:   time.Duration(c.HistoryMax.Age),
+				MaxBytes: c.HistoryMax.Bytes,
+			},
+		}),
+		server.WithShutdownGrace(time.Duration(c.ShutdownGrace)),
+		server.WithShutdownNotice(strings.TrimRight(c.ShutdownNotice, "\n") + "\n"),
+		server.WithCloseTimeout(time.Duration(c.CloseTimeout)),
+		server.WithOutbox(outbox.Config{
+			Size:         c.OutboxSize,
+			Policy:       policy,
+			WriteTimeout: time.Duration(c.WriteTimeout),
+		}),
+		server.WithMaxLine(c.MaxLineBytes, oversize),
+		server.WithNameTimeout(time.Duration(c.NameTimeout)),
+		server.WithResumeGrace(time.Duration(c.ResumeGrace)),
+		server.WithRenameCooldown(time.Duration(c.RenameCooldown)),
+		server.WithAutoAway(time.Duration(c.AutoAway)),
+		server.WithNameRules(models.NameRules{
+			MinLength:          c.NameRules.MinLength,
+			MaxLength:          c.NameRules.MaxLength,
+			AllowedPunctuation: c.NameRules.AllowedPunctuation,
+			Reserved:           c.NameRules.Reserved,
+		}),
+		server.WithFlood(flood.Config{
+			Burst:        c.Flood.Burst,
+			Refill:       time.Duration(c.Flood.Refill),
+			HostBurst:    c.Flood.HostBurst,
+			HostRefill:   time.Duration(c.Flood.HostRefill),
+			Repeats:      c.Flood.Repeats,
+			RepeatWindow: time.Duration(c.Flood.RepeatWindow),
+			Throttle:     time.Duration(c.Flood.Throttle),
+			Mute:         time.Duration(c.Flood.Mute),
+			Forgive:      time.Duration(c.Flood.Forgive),
+		}),
+		server.WithFeatures(models.Features{
+			Rooms:          c.Features.Rooms,
+			DirectMessages: c.Features.DirectMessages,
+			History:        c.Features.History,
+		}),
+	}
+	if len(c.AdminHosts) > 0 {
+		opts = append(opts, server.WithAdminSlot(c.AdminHosts...))
+	}
+	return opts
+}
+
+func (c Config) overflowPolicy() (outbox.Policy, error) {
+	for _, policy := range []outbox.Policy{outbox.DropOldest, outbox.DropNewest, outbox.Disconnect} {
+		if c.OverflowPolicy == policy.String() {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("overflow_policy %q must be drop-oldest, drop-newest or disconnect", c.OverflowPolicy)
+}
+
+func (c Config) oversizePolicy() (input.Policy, error) {
+	for _, policy := range []input.Policy{input.Reject, input.Truncate} {
+		if c.OversizePolicy == policy.String() {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("oversize_policy %q must be reject or truncate", c.OversizePolicy)
+}
@@ -0,0 +1,290 @@
+package flood
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action is how a client is dealt with for a line it sent
+type Action int
+
+const (
+	// Allow lets the line through
+	Allow Action = iota
+
+	// Drop discards the line without telling the client, while it is throttled
+	Drop
+
+	// Warn discards the line and warns the client
+	Warn
+
+	// Throttle discards the line and every line after it for a while
+	Throttle
+
+	// Mute discards the line and mutes the client
+	Mute
+
+	// Disconnect discards the line and closes the connection
+	Disconnect
+)
+
+// String returns the name of the action
+func (a Action) String() string {
+	switch a {
+	case Allow:
+		return "allow"
+	case Drop:
+		return "drop"
+	case Warn:
+		return "warn"
+	case Throttle:
+		return "throttle"
+	case Mute:
+		return "mute"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Reason is the rule a line broke
+type Reason int
+
+const (
+	// TooFast means the client or its address ran out of tokens
+	TooFast Reason = iota + 1
+
+	// Repeated means the client sent the same message too many times in a row
+	Repeated
+)
+
+// Config controls how fast clients may send and what happens when they
+// send faster. Each limit is disabled when its count is zero.
+type Config struct {
+	// Burst is how many lines a connection may send at once
+	Burst int
+
+	// Refill is how long a connection waits to earn one more line
+	Refill time.Duration
+
+	// HostBurst and HostRefill limit all connections from one address together
+	HostBurst  int
+	HostRefill time.Duration
+
+	// Repeats is how many times in a row the same message may be sent
+	// within RepeatWindow
+	Repeats      int
+	RepeatWindow time.Duration
+
+	// Throttle is how long lines are ignored after the second violation
+	Throttle time.Duration
+
+	// Mute is how long a client is muted after the third violation; the
+	// fourth disconnects it
+	Mute time.Duration
+
+	// Forgive clears the violations of a client that keeps to the limits
+	// for this long
+	Forgive time.Duration
+}
+
+// DefaultConfig is used when no flood configuration is given
+var DefaultConfig = Config{
+	Burst:        10,
+	Refill:       time.Second,
+	HostBurst:    30,
+	HostRefill:   300 * time.Millisecond,
+	Repeats:      3,
+	RepeatWindow: 30 * time.Second,
+	Throttle:     5 * time.Second,
+	Mute:         time.Minute,
+	Forgive:      time.Minute,
+}
+
+// Verdict is the outcome of checking one line
+type Verdict struct {
+	Action Action
+	Reason Reason
+
+	// For is how long a Throttle or Mute lasts
+	For time.Duration
+}
+
+// bucket is a token bucket holding up to burst tokens and earning one
+// every refill
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if one is left at now
+func (b *bucket) take(now time.Time, burst int, refill time.Duration) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if refill > 0 && now.After(b.last) {
+		b.tokens = min(float64(burst), b.tokens+float64(now.Sub(b.last))/float64(refill))
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket has refilled completely by now
+func (b *bucket) full(now time.Time, burst int, refill time.Duration) bool {
+	if b.last.IsZero() || refill <= 0 {
+		return b.last.IsZero()
+	}
+	return b.tokens+float64(now.Sub(b.last))/float64(refill) >= float64(burst)
+}
+
+// host is the bucket shared by the connections from one address
+type host struct {
+	bucket bucket
+	conns  int
+}
+
+// Limiter hands out a Guard per connection and keeps the buckets shared by
+// connections from the same address
+type Limiter struct {
+	cfg Config
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// New creates a limiter enforcing cfg
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, hosts: make(map[string]*host)}
+}
+
+// Config returns the limits the limiter enforces
+func (l *Limiter) Config() Config {
+	return l.cfg
+}
+
+// Guard starts tracking a connection from addr. Close the guard when the
+// connection ends.
+func (l *Limiter) Guard(addr string) *Guard {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Addresses are remembered until their bucket refills, so reconnecting
+	// does not reset the limit
+	now := time.Now()
+	for a, h := range l.hosts {
+		if h.conns == 0 && h.bucket.full(now, l.cfg.HostBurst, l.cfg.HostRefill) {
+			delete(l.hosts, a)
+		}
+	}
+
+	h, ok := l.hosts[addr]
+	if !ok {
+		h = &host{}
+		l.hosts[addr] = h
+	}
+	h.conns++
+	return &Guard{limiter: l, host: h}
+}
+
+// takeHost spends a token from the bucket shared by the guard's address
+func (l *Limiter) takeHost(h *host, now time.Time) bool {
+	if l.cfg.HostBurst <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return h.bucket.take(now, l.cfg.HostBurst, l.cfg.HostRefill)
+}
+
+// Guard applies the limits to the lines of a single connection. It is
+// used by one goroutine at a time.
+type Guard struct {
+	limiter *Limiter
+	host    *host
+	closed  bool
+
+	bucket bucket
+
+	last       string
+	lastAt     time.Time
+	repeats    int
+	strikes    int
+	strikeAt   time.Time
+	throttleTo time.Time
+}
+
+// Check judges a line received at now. Chat text is also checked for
+// repeats; pass an empty text for commands.
+func (g *Guard) Check(now time.Time, text string) Verdict {
+	cfg := g.limiter.cfg
+
+	if now.Before(g.throttleTo) {
+		return Verdict{Action: Drop, Reason: TooFast}
+	}
+
+	if cfg.Burst > 0 && !g.bucket.take(now, cfg.Burst, cfg.Refill) {
+		return g.strike(now, TooFast)
+	}
+	if !g.limiter.takeHost(g.host, now) {
+		return g.strike(now, TooFast)
+	}
+
+	if text != "" && cfg.Repeats > 0 {
+		if strings.EqualFold(text, g.last) && now.Sub(g.lastAt) <= cfg.RepeatWindow {
+			g.repeats++
+		} else {
+			g.repeats = 1
+		}
+		g.last, g.lastAt = text, now
+		if g.repeats > cfg.Repeats {
+			return g.strike(now, Repeated)
+		}
+	}
+
+	return Verdict{Action: Allow}
+}
+
+// strike records a violation and returns the response it earns: a
+// warning, then a throttle, then a mute, then a disconnect
+func (g *Guard) strike(now time.Time, reason Reason) Verdict {
+	cfg := g.limiter.cfg
+
+	if cfg.Forgive > 0 && g.strikes > 0 && now.Sub(g.strikeAt) >= cfg.Forgive {
+		g.strikes = 0
+	}
+	g.strikes++
+	g.strikeAt = now
+
+	switch g.strikes {
+	case 1:
+		return Verdict{Action: Warn, Reason: reason}
+	case 2:
+		g.throttleTo = now.Add(cfg.Throttle)
+		return Verdict{Action: Throttle, Reason: reason, For: cfg.Throttle}
+	case 3:
+		return Verdict{Action: Mute, Reason: reason, For: cfg.Mute}
+	}
+	return Verdict{Action: Disconnect, Reason: reason}
+}
+
+// Close stops tracking the connection
+func (g *Guard) Close() {
+	l := g.limiter
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if g.closed {
+		return
+	}
+	g.closed = true
+
+	g.host.conns--
+}
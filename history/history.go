@@ -0,0 +1,184 @@
+package history
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"netcat/logfile"
+)
+
+// DefaultCapacity is how many lines a room keeps in memory by default
+const DefaultCapacity = 1000
+
+// TimeLayout is the format timestamps are shown in by default. Logs
+// written before timestamps were stored in UTC use it in server local time.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// LogTimeLayout is the canonical format of timestamps in logs and history
+const LogTimeLayout = "2006-01-02T15:04:05Z"
+
+// entry is one line of history and the time it was said
+type entry struct {
+	time time.Time
+	line string
+}
+
+// Buffer keeps the most recent lines of a room in memory so history can be
+// replayed and paged without rereading the log
+type Buffer struct {
+	mu      sync.Mutex
+	entries []entry
+	start   int
+	count   int
+}
+
+// NewBuffer creates a buffer holding up to capacity lines
+func NewBuffer(capacity int) *Buffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Buffer{entries: make([]entry, capacity)}
+}
+
+// Add records a line. Lines starting with a timestamp are dated by it;
+// others, such as notices, take the time of the line before them.
+func (b *Buffer) Add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.add(line)
+}
+
+// Load fills the buffer from a log and its rotated segments, keeping the
+// most recent lines
+func (b *Buffer) Load(fileName string) error {
+	return b.LoadWith(fileName, nil)
+}
+
+// LoadWith is Load for logs whose records must be decoded into chat lines.
+// Records that decode to "" are skipped.
+func (b *Buffer) LoadWith(fileName string, decode func(record string) string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, segment := range logfile.Segments(fileName) {
+		file, err := os.Open(segment)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if decode != nil {
+				line = decode(line)
+			}
+			if line != "" {
+				b.add(line)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns how many lines the buffer holds
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.count
+}
+
+// Last returns up to n of the most recent lines, oldest first. A
+// non-positive n returns everything.
+func (b *Buffer) Last(n int) []string {
+	return b.Before(time.Time{}, n)
+}
+
+// Before returns up to n lines said before t, oldest first. A zero t means
+// no time bound and a non-positive n means no count bound.
+func (b *Buffer) Before(t time.Time, n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	end := b.count
+	if !t.IsZero() {
+		for end > 0 && !b.at(end-1).time.Before(t) {
+			end--
+		}
+	}
+
+	begin := 0
+	if n > 0 && end-n > 0 {
+		begin = end - n
+	}
+
+	lines := make([]string, 0, end-begin)
+	for i := begin; i < end; i++ {
+		lines = append(lines, b.at(i).line)
+	}
+	return lines
+}
+
+// Stamp formats t as a canonical UTC timestamp
+func Stamp(t time.Time) string {
+	return t.UTC().Format(LogTimeLayout)
+}
+
+// ParseTime reads the timestamp at the start of a chat line such as
+// "[2006-01-02T15:04:05Z][name]: text"
+func ParseTime(line string) (time.Time, bool) {
+	t, _, ok := Split(line)
+	return t, ok
+}
+
+// Split separates the timestamp at the start of a chat line from the rest
+// of the line. Both canonical UTC and older local timestamps are accepted.
+func Split(line string) (time.Time, string, bool) {
+	if !strings.HasPrefix(line, "[") {
+		return time.Time{}, line, false
+	}
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return time.Time{}, line, false
+	}
+
+	stamp := line[1:end]
+	t, err := time.Parse(LogTimeLayout, stamp)
+	if err != nil {
+		t, err = time.ParseInLocation(TimeLayout, stamp, time.Local)
+	}
+	if err != nil {
+		return time.Time{}, line, false
+	}
+	return t, line[end+1:], true
+}
+
+// add records a line. The caller must hold b.mu.
+func (b *Buffer) add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+
+	t, ok := ParseTime(line)
+	if !ok && b.count > 0 {
+		t = b.at(b.count - 1).time
+	}
+
+	if b.count < len(b.entries) {
+		b.entries[(b.start+b.count)%len(b.entries)] = entry{t, line}
+		b.count++
+		return
+	}
+	b.entries[b.start] = entry{t, line}
+	b.start = (b.start + 1) % len(b.entries)
+}
+
+// at returns the i-th oldest entry. The caller must hold b.mu.
+func (b *Buffer) at(i int) entry {
+	return b.entries[(b.start+i)%len(b.entries)]
+}
@@ -0,0 +1,119 @@
+package input
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// DefaultMaxLine is the longest line a client may send, in bytes
+const DefaultMaxLine = 2048
+
+var (
+	// ErrTooLong is returned by ReadLine in place of a line over the limit
+	// when the policy is Reject
+	ErrTooLong = errors.New("line too long")
+
+	// ErrTruncated is returned by ReadLine with a line cut to the limit
+	// when the policy is Truncate
+	ErrTruncated = errors.New("line truncated")
+)
+
+// Policy decides what happens to a line over the limit
+type Policy int
+
+const (
+	// Reject discards the whole line
+	Reject Policy = iota
+
+	// Truncate keeps the start of the line, up to the limit
+	Truncate
+)
+
+// String returns the name used for the policy in configuration
+func (p Policy) String() string {
+	switch p {
+	case Reject:
+		return "reject"
+	case Truncate:
+		return "truncate"
+	}
+	return "unknown"
+}
+
+// Reader reads newline terminated lines, holding no more than the limit
+// of any line in memory however long the client makes it
+type Reader struct {
+	r      *bufio.Reader
+	max    int
+	policy Policy
+}
+
+// NewReader reads lines of up to max bytes from r, applying policy to
+// longer ones. A max of zero or less uses DefaultMaxLine.
+func NewReader(r io.Reader, max int, policy Policy) *Reader {
+	if max <= 0 {
+		max = DefaultMaxLine
+	}
+	return &Reader{r: bufio.NewReader(r), max: max, policy: policy}
+}
+
+// Max returns the longest line the reader accepts, in bytes
+func (r *Reader) Max() int {
+	return r.max
+}
+
+// ReadLine returns the next line without its line ending. A line over the
+// limit is read to its end and then either dropped with ErrTooLong or cut
+// to the limit, on a character boundary, and returned with ErrTruncated.
+// A final line without a newline is returned with io.EOF.
+func (r *Reader) ReadLine() (string, error) {
+	var line []byte
+	tooLong := false
+
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(trimEOL(line)) > r.max {
+				tooLong = true
+				line = cut(line, r.max)
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return string(trimEOL(line)), err
+		}
+		break
+	}
+
+	if !tooLong {
+		return string(trimEOL(line)), nil
+	}
+	if r.policy == Truncate {
+		return string(line), ErrTruncated
+	}
+	return "", ErrTooLong
+}
+
+// trimEOL strips a trailing "\n" or "\r\n"
+func trimEOL(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+	}
+	return line
+}
+
+// cut shortens line to at most max bytes without splitting a character
+func cut(line []byte, max int) []byte {
+	for max > 0 && !utf8.RuneStart(line[max]) {
+		max--
+	}
+	return line[:max]
+}
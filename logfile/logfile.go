@@ -0,0 +1,267 @@
+package logfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retention bounds how much history is kept across all segments of a log.
+// Whole rotated segments are deleted, oldest first, until every limit is
+// met; the active segment is never deleted. Zero disables a limit.
+type Retention struct {
+	MaxLines int
+	MaxAge   time.Duration
+	MaxBytes int64
+}
+
+// Policy controls rotation and retention of a log
+type Policy struct {
+	// RotateBytes starts a new segment once the active one would grow
+	// beyond this size. Zero disables rotation.
+	RotateBytes int64
+
+	Retention Retention
+}
+
+// File is a chat log opened in append mode. When it grows beyond the
+// rotation size it is renamed to <path>.1, older segments move up by one
+// (<path>.1 becomes <path>.2 and so on) and a fresh file is started.
+type File struct {
+	path   string
+	policy Policy
+
+	mu      sync.Mutex
+	file    *os.File
+	active  segment
+	rotated []segment // oldest first
+}
+
+// segment is what retention needs to know about one file of the log
+type segment struct {
+	name    string
+	size    int64
+	lines   int
+	modTime time.Time
+}
+
+// Open opens the log at path for appending, creating it if needed, and
+// applies the retention policy to the existing segments
+func Open(path string, policy Policy) (*File, error) {
+	f := &File{path: path, policy: policy}
+
+	for _, n := range rotated(path) {
+		stat, err := statSegment(segmentName(path, n))
+		if err != nil {
+			return nil, err
+		}
+		f.rotated = append(f.rotated, stat)
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if err := f.enforceRetention(); err != nil {
+		f.file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the active segment, rotating first if p would take it
+// past the rotation size, then drops old segments the retention policy no
+// longer allows
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.policy.RotateBytes > 0 && f.active.size > 0 && f.active.size+int64(len(p)) > f.policy.RotateBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.active.size += int64(n)
+	f.active.lines += bytes.Count(p[:n], []byte{'\n'})
+	f.active.modTime = time.Now()
+	if err != nil {
+		return n, err
+	}
+	return n, f.enforceRetention()
+}
+
+// Sync commits the active segment to disk
+func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close closes the active segment
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Segments returns the files holding the log at path, oldest first. Only
+// files that exist are returned.
+func Segments(path string) []string {
+	var segments []string
+	for _, n := range rotated(path) {
+		segments = append(segments, segmentName(path, n))
+	}
+	if _, err := os.Stat(path); err == nil {
+		segments = append(segments, path)
+	}
+	return segments
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	stat, err := statSegment(f.path)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.active = stat
+	return nil
+}
+
+// rotate moves the active segment to <path>.1 and opens a new one. The
+// caller must hold f.mu.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	// Shift from the oldest down so no segment is overwritten
+	for i := range f.rotated {
+		name := segmentName(f.path, len(f.rotated)-i+1)
+		if err := os.Rename(f.rotated[i].name, name); err != nil {
+			return err
+		}
+		f.rotated[i].name = name
+	}
+
+	retired := f.active
+	retired.name = segmentName(f.path, 1)
+	if err := os.Rename(f.path, retired.name); err != nil {
+		return err
+	}
+	f.rotated = append(f.rotated, retired)
+
+	return f.open()
+}
+
+// enforceRetention deletes the oldest rotated segments until the log fits
+// the retention policy. The active segment is always kept. The caller must
+// hold f.mu or have exclusive access to f.
+func (f *File) enforceRetention() error {
+	limits := f.policy.Retention
+	if limits == (Retention{}) {
+		return nil
+	}
+
+	totalBytes := f.active.size
+	totalLines := f.active.lines
+	for _, seg := range f.rotated {
+		totalBytes += seg.size
+		totalLines += seg.lines
+	}
+
+	for len(f.rotated) > 0 {
+		oldest := f.rotated[0]
+		tooOld := limits.MaxAge > 0 && time.Since(oldest.modTime) > limits.MaxAge
+		tooBig := limits.MaxBytes > 0 && totalBytes > limits.MaxBytes
+		tooLong := limits.MaxLines > 0 && totalLines > limits.MaxLines
+		if !tooOld && !tooBig && !tooLong {
+			break
+		}
+
+		if err := os.Remove(oldest.name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing old log segment: %w", err)
+		}
+		totalBytes -= oldest.size
+		totalLines -= oldest.lines
+		f.rotated = f.rotated[1:]
+	}
+	return nil
+}
+
+// rotated returns the numbers of the existing rotated segments of path in
+// descending order, so the oldest comes first
+func rotated(path string) []int {
+	matches, _ := filepath.Glob(path + ".*")
+
+	var numbers []int
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	return numbers
+}
+
+func segmentName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// statSegment reads the size, line count and age of a log file
+func statSegment(name string) (segment, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return segment{}, err
+	}
+	lines, err := countLines(name)
+	if err != nil {
+		return segment{}, err
+	}
+	return segment{name: name, size: info.Size(), lines: lines, modTime: info.ModTime()}, nil
+}
+
+func countLines(name string) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	lines := 0
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(buffer)
+		lines += bytes.Count(buffer[:n], []byte{'\n'})
+		if err != nil {
+			break
+		}
+	}
+	return lines, nil
+}
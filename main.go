@@ -1,26 +1,96 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // so /set tz works on hosts without a zoneinfo database
+
+	"netcat/certs"
+	"netcat/config"
+	"netcat/replay"
 	"netcat/server"
 )
 
 func main() {
-	port := ":9060"
+	// "replay" renders a chat log and "gencert" writes a development
+	// certificate instead of running the server
+	if len(os.Args) > 1 {
+		var run func([]string, io.Writer, io.Writer) error
+		switch os.Args[1] {
+		case "replay":
+			run = replay.Run
+		case "gencert":
+			run = certs.Run
+		}
+		if run != nil {
+			err := run(os.Args[2:], os.Stdout, os.Stderr)
+			if err != nil && !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			return
+		}
+	}
 
-	// Get port from command line argument
-	if len(os.Args) == 2 {
-		port = ":" + os.Args[1]
-	} else if len(os.Args) > 2 {
-		fmt.Println("[USAGE]: ./TCPChat $port")
+	// Read flags, the optional config file and the legacy port argument
+	cfg, err := config.Parse(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
 		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	// Start server
-	err := server.InitServer(port)
+	tlsConfig, err := cfg.TLSConfig()
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+
+	srv := server.New(cfg.Options()...)
+
+	// Shut down gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Println("Shutting down...")
+
+		timeout := time.Duration(cfg.ShutdownGrace) + time.Duration(cfg.CloseTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown did not complete: %v", err)
+		}
+	}()
+
+	// Start the plain and TLS listeners side by side
+	errs := make(chan error, 2)
+	listeners := 0
+	if cfg.Plain {
+		listeners++
+		go func() { errs <- srv.ListenAndServe(cfg.Addr()) }()
+	}
+	if tlsConfig != nil {
+		listeners++
+		go func() { errs <- srv.ListenAndServeTLS(cfg.TLSAddr(), tlsConfig) }()
+	}
+	for i := 0; i < listeners; i++ {
+		if err := <-errs; err != nil && !errors.Is(err, server.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}
+
+	// Wait for the shutdown sequence to finish before exiting
+	srv.Shutdown(context.Background())
 }
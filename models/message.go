@@ -0,0 +1,214 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"netcat/history"
+	"netcat/style"
+)
+
+// Kind says what a message is about
+type Kind int
+
+const (
+	// KindSystem is a notice from the server; its body is shown as is
+	KindSystem Kind = iota
+	KindChat
+	KindJoin
+	KindLeave
+	KindRename
+	KindDirect
+	KindKick
+	KindMute
+	KindUnmute
+	KindBan
+	KindUnban
+)
+
+var kindNames = [...]string{"system", "chat", "join", "leave", "rename", "dm", "kick", "mute", "unmute", "ban", "unban"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// ParseKind is the inverse of Kind.String
+func ParseKind(s string) (Kind, error) {
+	for i, name := range kindNames {
+		if name == s {
+			return Kind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown message kind %q", s)
+}
+
+// MarshalText encodes a kind by name
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a kind by name
+func (k *Kind) UnmarshalText(text []byte) error {
+	kind, err := ParseKind(string(text))
+	if err != nil {
+		return err
+	}
+	*k = kind
+	return nil
+}
+
+// Message is an event sent to the members of a room. An empty Room
+// addresses every room on the server. Messages are turned into text only
+// when they are logged or delivered, so each recipient can see them in
+// their own format.
+//
+// Meta carries details specific to a kind: "from" is the room a joining
+// user came from, "to" is the room a leaving user went to or the recipient
+// of a direct message, and "old" is the previous name of a renamed user.
+// Moderation messages are sent by the operator and name the "target", with
+// an optional "reason" and "duration".
+type Message struct {
+	ID     uint64            `json:"id"`
+	Kind   Kind              `json:"kind"`
+	Sender string            `json:"sender,omitempty"`
+	Room   string            `json:"room,omitempty"`
+	Time   time.Time         `json:"time"`
+	Body   string            `json:"body,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"`
+}
+
+// NewMessage creates a message of the given kind stamped with the current time
+func NewMessage(kind Kind, sender, room, body string) Message {
+	return Message{Kind: kind, Sender: sender, Room: room, Time: time.Now(), Body: body}
+}
+
+// Line returns the message as it is logged, stamped in UTC
+func (m Message) Line() string {
+	return m.render("", history.Stamp(m.Time), Prefs{})
+}
+
+// Render returns the message as shown to the user named viewer with the
+// given preferences. Users are not told about their own joins, leaves and
+// renames, so Render returns "" for those.
+func (m Message) Render(viewer string, p Prefs) string {
+	switch m.Kind {
+	case KindJoin, KindLeave, KindRename:
+		if viewer != "" && viewer == m.Sender {
+			return ""
+		}
+	}
+	return m.render(viewer, p.Stamp(m.Time), p)
+}
+
+func (m Message) render(viewer, stamp string, p Prefs) string {
+	switch m.Kind {
+	case KindChat:
+		return fmt.Sprintf("[%s][%s]: %s\n", stamp, p.name(m.Sender), p.text(m.Body))
+	case KindJoin:
+		if m.Meta["from"] == "" {
+			return fmt.Sprintf("%s has joined our chat...\n", p.name(m.Sender))
+		}
+		return fmt.Sprintf("%s has joined #%s...\n", p.name(m.Sender), m.Room)
+	case KindLeave:
+		if m.Meta["to"] == "" {
+			return fmt.Sprintf("%s has left our chat.\n", p.name(m.Sender))
+		}
+		return fmt.Sprintf("%s has left #%s.\n", p.name(m.Sender), m.Room)
+	case KindRename:
+		return fmt.Sprintf("%s has changed their name to %s\n", m.Meta["old"], p.name(m.Sender))
+	case KindDirect:
+		if viewer == m.Sender {
+			return fmt.Sprintf("[%s][DM to %s]: %s\n", stamp, p.name(m.Meta["to"]), p.text(m.Body))
+		}
+		return fmt.Sprintf("[%s][DM from %s]: %s\n", stamp, p.name(m.Sender), p.text(m.Body))
+	case KindKick, KindMute, KindUnmute, KindBan, KindUnban:
+		return m.renderModeration()
+	}
+
+	if m.Body == "" || strings.HasSuffix(m.Body, "\n") {
+		return m.Body
+	}
+	return m.Body + "\n"
+}
+
+var moderationVerbs = map[Kind]string{
+	KindKick:   "kicked",
+	KindMute:   "muted",
+	KindUnmute: "unmuted",
+	KindBan:    "banned",
+	KindUnban:  "unbanned",
+}
+
+// renderModeration describes an operator action, such as
+// "bob was banned by alice for 1h0m0s: spamming"
+func (m Message) renderModeration() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s was %s by %s", m.Meta["target"], moderationVerbs[m.Kind], m.Sender)
+	if duration := m.Meta["duration"]; duration != "" {
+		b.WriteString(" for " + duration)
+	}
+	if reason := m.Meta["reason"]; reason != "" {
+		b.WriteString(": " + reason)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Prefs are a user's display settings. The zero value shows timestamps in
+// the default format and the server's timezone, in plain text.
+type Prefs struct {
+	TimeFormat string
+	Location   *time.Location
+
+	// Color draws names in their own colors and renders *bold* and
+	// _italic_ markup as ANSI styles
+	Color bool
+}
+
+// Stamp formats t for display
+func (p Prefs) Stamp(t time.Time) string {
+	layout := p.TimeFormat
+	if layout == "" {
+		layout = history.TimeLayout
+	}
+	loc := p.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return t.In(loc).Format(layout)
+}
+
+// Render restamps a line from the log or history for display, coloring
+// the sender of chat lines for users who asked for color
+func (p Prefs) Render(line string) string {
+	t, rest, ok := history.Split(line)
+	if !ok {
+		return line
+	}
+	if p.Color && strings.HasPrefix(rest, "[") {
+		if name, body, found := strings.Cut(rest[1:], "]: "); found {
+			rest = "[" + p.name(name) + "]: " + p.text(body)
+		}
+	}
+	return "[" + p.Stamp(t) + "]" + rest
+}
+
+// name draws a user name in its color when color is on
+func (p Prefs) name(name string) string {
+	if !p.Color {
+		return name
+	}
+	return style.Name(name)
+}
+
+// text renders markup in a message body when color is on
+func (p Prefs) text(body string) string {
+	if !p.Color {
+		return body
+	}
+	return style.Markup(body)
+}
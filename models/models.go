@@ -1,14 +1,304 @@
 package models
 
 import (
+	"io"
 	"net"
-	"os"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"netcat/accounts"
+	"netcat/bans"
+	"netcat/flood"
+	"netcat/history"
+	"netcat/input"
+	"netcat/outbox"
 )
 
-var (
-	Clients   = make(map[net.Conn]string)
-	Broadcast = make(chan string)
-	Mu        sync.Mutex
-	LogFile   *os.File
+const (
+	// Lobby is the room every client starts in
+	Lobby = "lobby"
+
+	// DefaultHistoryLines is how many lines of history joining clients receive
+	DefaultHistoryLines = 50
+
+	// DefaultNameTimeout is how long a new connection has to pick a name
+	DefaultNameTimeout = time.Minute
+
+	// DefaultResumeGrace is how long the session of a dropped connection
+	// waits to be resumed when resuming is turned on
+	DefaultResumeGrace = 2 * time.Minute
+
+	// DefaultResumeBacklog is how many missed messages a waiting session keeps
+	DefaultResumeBacklog = 200
+
+	// DefaultRenameHistory is how many name changes /whowas remembers
+	DefaultRenameHistory = 1000
+
+	// DefaultRenameCooldown is how long a user waits between renames when
+	// a cooldown is turned on
+	DefaultRenameCooldown = 10 * time.Second
+
+	// DefaultAutoAway is how long a user stays quiet before being marked
+	// as away when automatic away is turned on
+	DefaultAutoAway = 15 * time.Minute
 )
+
+// Room is a named group of clients sharing messages and a history log
+type Room struct {
+	Name        string
+	Members     map[net.Conn]struct{}
+	LogFile     io.Writer
+	HistoryFile string
+	History     *history.Buffer
+}
+
+// NameRules controls which usernames clients may pick
+type NameRules struct {
+	MinLength int
+	MaxLength int
+
+	// AllowedPunctuation lists the characters besides letters and digits
+	// that may appear in a name
+	AllowedPunctuation string
+
+	// Reserved names cannot be claimed by clients, regardless of case
+	Reserved []string
+}
+
+// DefaultNameRules are the name rules of a new hub
+var DefaultNameRules = NameRules{
+	MinLength:          1,
+	MaxLength:          20,
+	AllowedPunctuation: "-_.",
+	Reserved:           []string{"server", "admin"},
+}
+
+// Features switches optional chat features on or off
+type Features struct {
+	Rooms          bool
+	DirectMessages bool
+	History        bool
+}
+
+// DefaultFeatures enables everything
+var DefaultFeatures = Features{
+	Rooms:          true,
+	DirectMessages: true,
+	History:        true,
+}
+
+// Client is what the hub knows about a connected client
+type Client struct {
+	Name string
+
+	// Addr is the remote address of the connection
+	Addr string
+
+	// Joined is when the client joined the chat and Connected when its
+	// current connection did; they differ once a session is resumed
+	Joined    time.Time
+	Connected time.Time
+
+	// Active is when the client last sent a line
+	Active time.Time
+
+	// Away is the client's away message, empty while it is present.
+	// AutoAway is set when it was marked away for being idle, so its next
+	// line brings it back.
+	Away     string
+	AutoAway bool
+}
+
+// NewClient describes a client called name that just joined over conn
+func NewClient(conn net.Conn, name string) *Client {
+	now := time.Now()
+	client := &Client{Name: name, Joined: now, Connected: now, Active: now}
+	if addr := conn.RemoteAddr(); addr != nil {
+		client.Addr = addr.String()
+	}
+	return client
+}
+
+// Rename records a name change
+type Rename struct {
+	Old  string
+	New  string
+	Time time.Time
+}
+
+// Session is what a client whose connection dropped leaves behind: its
+// name stays reserved and the messages it misses are kept until it is
+// resumed with its token or the grace period ends.
+type Session struct {
+	Token    string
+	Name     string
+	Account  string
+	Room     string
+	Prefs    Prefs
+	Operator bool
+	ReplyTo  string
+	Away     string
+	Joined   time.Time
+	Since    time.Time
+
+	// Missed holds the newest messages sent to the session while it was
+	// away; Dropped counts older ones that did not fit
+	Missed  []Message
+	Dropped int
+
+	Expiry *time.Timer
+}
+
+// Hub holds the shared state of a single chat server: the connected
+// clients, the rooms they are in and the broadcast channel feeding the
+// broadcaster.
+type Hub struct {
+	Clients    map[net.Conn]*Client
+	ClientRoom map[net.Conn]string
+	ReplyTo    map[net.Conn]string
+	Outboxes   map[net.Conn]*outbox.Outbox
+	Prefs      map[net.Conn]Prefs
+	Operators  map[net.Conn]struct{}
+	Rooms      map[string]*Room
+	Broadcast  chan Message
+	Mu         sync.Mutex
+	NameRules  NameRules
+	Outbox     outbox.Config
+	Features   Features
+
+	// Muted maps the lowercased names of muted users to when their mute
+	// ends; the zero time lasts until they are unmuted
+	Muted map[string]time.Time
+
+	// Flood limits how fast clients may send
+	Flood *flood.Limiter
+
+	// Bans lists the names and addresses refused by the server
+	Bans *bans.List
+
+	// Accounts holds the registered names; joining with one of them asks
+	// for its password
+	Accounts *accounts.Store
+
+	// OpPassword lets users become operators with /op; /op is disabled
+	// when it is empty
+	OpPassword string
+
+	// MaxLine is the longest line a client may send, in bytes; Oversize
+	// decides whether longer lines are cut or refused
+	MaxLine  int
+	Oversize input.Policy
+
+	// Tokens maps each connected client to the token that resumes its
+	// session if the connection drops
+	Tokens map[net.Conn]string
+
+	// Detached maps resume tokens to the sessions of dropped connections
+	Detached map[string]*Session
+
+	// ResumeGrace is how long a dropped session can be resumed; zero, the
+	// default, announces the departure at once. ResumeBacklog bounds the messages
+	// kept for it.
+	ResumeGrace   time.Duration
+	ResumeBacklog int
+
+	// Expiring tracks sessions whose grace period ended but whose
+	// departure is still being announced
+	Expiring sync.WaitGroup
+
+	// Renames lists the most recent name changes, oldest first, up to
+	// RenameHistory of them
+	Renames       []Rename
+	RenameHistory int
+
+	// RenameCooldown is how long a user must wait between renames; zero
+	// allows renaming at any time
+	RenameCooldown time.Duration
+
+	// AutoAway is how long a client may send nothing before it is marked
+	// as away; zero never marks anyone automatically
+	AutoAway time.Duration
+
+	// NameTimeout is how long a new connection has to pick a name before
+	// it is closed; zero waits forever
+	NameTimeout time.Duration
+
+	// BannerFile is shown to every new connection before the name prompt
+	BannerFile string
+
+	// HistoryLines is how many lines of history a joining client receives;
+	// zero sends everything kept in memory
+	HistoryLines int
+
+	// HistoryBuffer is how many lines of history each room keeps in memory
+	HistoryBuffer int
+
+	// OpenRoomLog opens the log a new room writes to and returns the file
+	// its history is replayed from. Rooms are not logged when it is nil.
+	OpenRoomLog func(room string) (io.Writer, string, error)
+
+	// CloseRoomLog closes a log opened by OpenRoomLog once its room has
+	// been removed. Logs stay open when it is nil.
+	CloseRoomLog func(io.Writer)
+
+	// EncodeLog turns a message into the record written to the room logs.
+	// Messages are logged as plain text lines when it is nil.
+	EncodeLog func(Message) string
+
+	lastID atomic.Uint64
+}
+
+// NewRoom creates an empty room
+func NewRoom(name string) *Room {
+	return &Room{
+		Name:    name,
+		Members: make(map[net.Conn]struct{}),
+		History: history.NewBuffer(history.DefaultCapacity),
+	}
+}
+
+// NextID returns a new message ID, unique within the hub
+func (h *Hub) NextID() uint64 {
+	return h.lastID.Add(1)
+}
+
+// Name returns the name of the client using conn, or "" when conn has not
+// joined. The caller must hold h.Mu.
+func (h *Hub) Name(conn net.Conn) string {
+	if client, ok := h.Clients[conn]; ok {
+		return client.Name
+	}
+	return ""
+}
+
+// NewHub creates a hub containing only the lobby
+func NewHub() *Hub {
+	return &Hub{
+		Clients:    make(map[net.Conn]*Client),
+		ClientRoom: make(map[net.Conn]string),
+		ReplyTo:    make(map[net.Conn]string),
+		Outboxes:   make(map[net.Conn]*outbox.Outbox),
+		Prefs:      make(map[net.Conn]Prefs),
+		Operators:  make(map[net.Conn]struct{}),
+		Muted:      make(map[string]time.Time),
+		Tokens:     make(map[net.Conn]string),
+		Detached:   make(map[string]*Session),
+		Bans:       bans.New(),
+		Accounts:   accounts.New(),
+		Flood:      flood.New(flood.DefaultConfig),
+		Rooms:      map[string]*Room{Lobby: NewRoom(Lobby)},
+		Broadcast:  make(chan Message),
+		NameRules:  DefaultNameRules,
+		Outbox:     outbox.DefaultConfig,
+		Features:   DefaultFeatures,
+		BannerFile: "logo.txt",
+
+		HistoryLines:  DefaultHistoryLines,
+		HistoryBuffer: history.DefaultCapacity,
+		MaxLine:       input.DefaultMaxLine,
+		NameTimeout:   DefaultNameTimeout,
+		ResumeBacklog: DefaultResumeBacklog,
+		RenameHistory: DefaultRenameHistory,
+	}
+}
@@ -0,0 +1,332 @@
+package moderation
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"netcat/bans"
+	"netcat/models"
+	"netcat/names"
+	"netcat/sessions"
+	"netcat/utils"
+)
+
+var (
+	// ErrNotOperator is returned when a user without the operator role
+	// tries a moderation command
+	ErrNotOperator = errors.New("you are not an operator")
+
+	// ErrOpDisabled is returned by Op when the server has no operator password
+	ErrOpDisabled = errors.New("operator login is disabled on this server")
+
+	// ErrWrongPassword is returned by Op for a wrong password
+	ErrWrongPassword = errors.New("wrong operator password")
+
+	// ErrSelf is returned when an operator targets themselves
+	ErrSelf = errors.New("you cannot do that to yourself")
+
+	// ErrNotBanned is returned by Unban when there is no such ban
+	ErrNotBanned = errors.New("no such ban")
+)
+
+// ServerName is who actions taken by the server itself are credited to
+const ServerName = "server"
+
+// noticeTimeout bounds the farewell written to a removed client without an outbox
+const noticeTimeout = time.Second
+
+// IsOperator reports whether conn has the operator role
+func IsOperator(hub *models.Hub, conn net.Conn) bool {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	_, ok := hub.Operators[conn]
+	return ok
+}
+
+// Grant gives conn the operator role
+func Grant(hub *models.Hub, conn net.Conn) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	hub.Operators[conn] = struct{}{}
+}
+
+// Op grants conn the operator role if password is the server's operator password
+func Op(hub *models.Hub, conn net.Conn, password string) error {
+	if hub.OpPassword == "" {
+		return ErrOpDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(hub.OpPassword)) != 1 {
+		return ErrWrongPassword
+	}
+	Grant(hub, conn)
+	return nil
+}
+
+// Kick disconnects the user called name
+func Kick(hub *models.Hub, by net.Conn, name, reason string) error {
+	hub.Mu.Lock()
+	op, err := operator(hub, by)
+	if err != nil {
+		hub.Mu.Unlock()
+		return err
+	}
+	target, name, ok := names.Find(hub, name)
+	if !ok {
+		hub.Mu.Unlock()
+		return utils.ErrNoSuchUser
+	}
+	if target == by {
+		hub.Mu.Unlock()
+		return ErrSelf
+	}
+	disconnect(hub, target, farewell("kicked", op, 0, reason))
+	hub.Mu.Unlock()
+
+	announce(hub, models.KindKick, op, name, 0, reason)
+	return nil
+}
+
+// Mute stops the user called name from chatting for d, or until they are
+// unmuted when d is zero
+func Mute(hub *models.Hub, by net.Conn, name string, d time.Duration) error {
+	hub.Mu.Lock()
+	op, err := operator(hub, by)
+	if err != nil {
+		hub.Mu.Unlock()
+		return err
+	}
+	target, name, ok := names.Find(hub, name)
+	if !ok {
+		hub.Mu.Unlock()
+		return utils.ErrNoSuchUser
+	}
+	if target == by {
+		hub.Mu.Unlock()
+		return ErrSelf
+	}
+
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	hub.Muted[strings.ToLower(name)] = until
+	hub.Mu.Unlock()
+
+	announce(hub, models.KindMute, op, name, d, "")
+	return nil
+}
+
+// Silence mutes name for d on the server's behalf, as when a client floods
+// the chat
+func Silence(hub *models.Hub, name string, d time.Duration, reason string) {
+	hub.Mu.Lock()
+	hub.Muted[strings.ToLower(name)] = time.Now().Add(d)
+	hub.Mu.Unlock()
+
+	announce(hub, models.KindMute, ServerName, name, d, reason)
+}
+
+// Unmute lets the user called name chat again
+func Unmute(hub *models.Hub, by net.Conn, name string) error {
+	hub.Mu.Lock()
+	op, err := operator(hub, by)
+	if err != nil {
+		hub.Mu.Unlock()
+		return err
+	}
+	if _, ok := hub.Muted[strings.ToLower(name)]; !ok {
+		hub.Mu.Unlock()
+		return fmt.Errorf("%s is not muted", name)
+	}
+	delete(hub.Muted, strings.ToLower(name))
+	hub.Mu.Unlock()
+
+	announce(hub, models.KindUnmute, op, name, 0, "")
+	return nil
+}
+
+// MutedFor reports whether name is muted and for how much longer. The
+// remaining time is zero for mutes without an end.
+func MutedFor(hub *models.Hub, name string) (time.Duration, bool) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	until, ok := hub.Muted[strings.ToLower(name)]
+	if !ok {
+		return 0, false
+	}
+	if until.IsZero() {
+		return 0, true
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		delete(hub.Muted, strings.ToLower(name))
+		return 0, false
+	}
+	return remaining, true
+}
+
+// Ban refuses a name or an IP address for d, or for good when d is zero,
+// and disconnects the clients it matches. The ban is saved to the server's
+// ban list.
+func Ban(hub *models.Hub, by net.Conn, target string, d time.Duration, reason string) error {
+	hub.Mu.Lock()
+	op, err := operator(hub, by)
+	if err != nil {
+		hub.Mu.Unlock()
+		return err
+	}
+
+	entry := bans.Entry{By: op, Reason: reason, Time: time.Now()}
+	if d > 0 {
+		entry.Until = entry.Time.Add(d)
+	}
+
+	var matched []net.Conn
+	if net.ParseIP(target) != nil {
+		entry.IP = target
+		for conn := range hub.Clients {
+			if Host(conn.RemoteAddr()) == target {
+				matched = append(matched, conn)
+			}
+		}
+	} else {
+		entry.Name = target
+		if conn, name, ok := names.Find(hub, target); ok {
+			entry.Name = name
+			matched = append(matched, conn)
+		}
+	}
+	for _, conn := range matched {
+		if conn == by {
+			hub.Mu.Unlock()
+			return ErrSelf
+		}
+	}
+
+	if err := hub.Bans.Add(entry); err != nil {
+		hub.Mu.Unlock()
+		return fmt.Errorf("saving ban: %w", err)
+	}
+	for _, conn := range matched {
+		disconnect(hub, conn, farewell("banned", op, d, reason))
+	}
+	hub.Mu.Unlock()
+
+	announce(hub, models.KindBan, op, entry.Target(), d, reason)
+	return nil
+}
+
+// Unban lifts the ban on a name or IP address
+func Unban(hub *models.Hub, by net.Conn, target string) error {
+	hub.Mu.Lock()
+	op, err := operator(hub, by)
+	hub.Mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	removed, err := hub.Bans.Remove(target)
+	if err != nil {
+		return fmt.Errorf("saving ban list: %w", err)
+	}
+	if !removed {
+		return ErrNotBanned
+	}
+
+	announce(hub, models.KindUnban, op, target, 0, "")
+	return nil
+}
+
+// BanNotice is what a banned client is told before being disconnected
+func BanNotice(entry bans.Entry) string {
+	msg := "You are banned from this server"
+	if !entry.Until.IsZero() {
+		msg += " until " + models.Prefs{}.Stamp(entry.Until)
+	}
+	if entry.Reason != "" {
+		msg += ": " + entry.Reason
+	}
+	return msg + ".\n"
+}
+
+// Host returns the IP address of a remote address without its port
+func Host(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// FormatDuration shortens durations such as 1h0m0s to 1h
+func FormatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// operator returns the name of the operator using conn. The caller must
+// hold hub.Mu.
+func operator(hub *models.Hub, conn net.Conn) (string, error) {
+	if _, ok := hub.Operators[conn]; !ok {
+		return "", ErrNotOperator
+	}
+	return hub.Name(conn), nil
+}
+
+// announce tells every room about an operator action, which also writes it
+// to the room logs
+func announce(hub *models.Hub, kind models.Kind, op, target string, d time.Duration, reason string) {
+	msg := models.NewMessage(kind, op, "", "")
+	msg.Meta = map[string]string{"target": target}
+	if d > 0 {
+		msg.Meta["duration"] = FormatDuration(d)
+	}
+	if reason != "" {
+		msg.Meta["reason"] = reason
+	}
+	hub.Broadcast <- msg
+}
+
+// farewell is what a removed client is told
+func farewell(action, op string, d time.Duration, reason string) string {
+	msg := fmt.Sprintf("You have been %s by %s", action, op)
+	if d > 0 {
+		msg += " for " + FormatDuration(d)
+	}
+	if reason != "" {
+		msg += ": " + reason
+	}
+	return msg + ".\n"
+}
+
+// disconnect sends conn a last notice and closes it once the notice is
+// written. The caller must hold hub.Mu.
+func disconnect(hub *models.Hub, conn net.Conn, notice string) {
+	// A removed client may not come back with its resume token
+	sessions.Revoke(hub, conn)
+
+	if box, ok := hub.Outboxes[conn]; ok {
+		box.Send(notice)
+		go box.Close()
+		return
+	}
+
+	go func() {
+		conn.SetWriteDeadline(time.Now().Add(noticeTimeout))
+		conn.Write([]byte(notice))
+		conn.Close()
+	}()
+}
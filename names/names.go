@@ -0,0 +1,137 @@
+package names
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"netcat/models"
+	"netcat/sessions"
+)
+
+var (
+	// ErrInvalidName is wrapped by every error returned for a name that breaks the rules
+	ErrInvalidName = errors.New("invalid name")
+
+	// ErrNameTaken is returned when another client already uses the name
+	ErrNameTaken = errors.New("name is already taken")
+
+	// ErrNameReserved is returned for names on the reserved list
+	ErrNameReserved = errors.New("name is reserved")
+)
+
+// Validate checks name against the rules, ignoring other clients
+func Validate(rules models.NameRules, name string) error {
+	length := utf8.RuneCountInString(name)
+	if length < rules.MinLength || rules.MaxLength > 0 && length > rules.MaxLength {
+		return fmt.Errorf("%w: use %d to %d characters", ErrInvalidName, rules.MinLength, rules.MaxLength)
+	}
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(rules.AllowedPunctuation, r) {
+			continue
+		}
+		if rules.AllowedPunctuation == "" {
+			return fmt.Errorf("%w: use only letters and digits", ErrInvalidName)
+		}
+		return fmt.Errorf("%w: use only letters, digits and %q", ErrInvalidName, rules.AllowedPunctuation)
+	}
+
+	for _, reserved := range rules.Reserved {
+		if strings.EqualFold(name, reserved) {
+			return fmt.Errorf("%w: %w", ErrInvalidName, ErrNameReserved)
+		}
+	}
+	return nil
+}
+
+// Available reports why conn could not claim name, or nil if it could
+func Available(hub *models.Hub, conn net.Conn, name string) error {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	return available(hub, conn, name)
+}
+
+// available is Available for callers already holding hub.Mu
+func available(hub *models.Hub, conn net.Conn, name string) error {
+	if err := Validate(hub.NameRules, name); err != nil {
+		return err
+	}
+
+	for other, client := range hub.Clients {
+		if other != conn && strings.EqualFold(client.Name, name) {
+			return fmt.Errorf("%w: %w", ErrInvalidName, ErrNameTaken)
+		}
+	}
+	// A dropped client keeps its name until its session expires
+	if session, ok := sessions.Find(hub, name); ok && !strings.EqualFold(session.Name, hub.Name(conn)) {
+		return fmt.Errorf("%w: %w", ErrInvalidName, ErrNameTaken)
+	}
+	return nil
+}
+
+// Claim validates name and assigns it to conn unless another client already
+// uses it, ignoring case. It returns the name conn had before ("" if none).
+func Claim(hub *models.Hub, conn net.Conn, name string) (string, error) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	if err := available(hub, conn, name); err != nil {
+		return "", err
+	}
+
+	client, ok := hub.Clients[conn]
+	if !ok {
+		hub.Clients[conn] = models.NewClient(conn, name)
+		return "", nil
+	}
+	previous := client.Name
+	client.Name = name
+	return previous, nil
+}
+
+// Find returns the connection of the client using name, ignoring case, and
+// the name as that client spelled it. The caller must hold hub.Mu.
+func Find(hub *models.Hub, name string) (net.Conn, string, bool) {
+	for conn, client := range hub.Clients {
+		if strings.EqualFold(client.Name, name) {
+			return conn, client.Name, true
+		}
+	}
+	return nil, "", false
+}
+
+// Record remembers that old was renamed to name at t, forgetting the
+// oldest change once the hub's rename history is full. The caller must
+// hold hub.Mu.
+func Record(hub *models.Hub, old, name string, t time.Time) {
+	if hub.RenameHistory <= 0 {
+		return
+	}
+	if len(hub.Renames) >= hub.RenameHistory {
+		hub.Renames = hub.Renames[1:]
+	}
+	hub.Renames = append(hub.Renames, models.Rename{Old: old, New: name, Time: t})
+}
+
+// History returns the remembered name changes to or from name, oldest
+// first, and the name its user goes by after the last of them, following
+// later renames. The caller must hold hub.Mu.
+func History(hub *models.Hub, name string) ([]models.Rename, string) {
+	var changes []models.Rename
+	current := name
+	for _, r := range hub.Renames {
+		if strings.EqualFold(r.Old, name) || strings.EqualFold(r.New, name) {
+			changes = append(changes, r)
+		}
+		if strings.EqualFold(r.Old, current) {
+			current = r.New
+		}
+	}
+	return changes, current
+}
@@ -0,0 +1,203 @@
+package outbox
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Policy decides what happens when a message is sent to a full outbox
+type Policy int
+
+const (
+	// DropOldest discards the oldest queued message to make room
+	DropOldest Policy = iota
+
+	// DropNewest discards the message being sent
+	DropNewest
+
+	// Disconnect closes the client's connection
+	Disconnect
+)
+
+// String returns the name used for the policy in configuration
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Config controls the outbox of every client
+type Config struct {
+	// Size is how many messages may wait for a slow client
+	Size int
+
+	// Policy is applied when the queue is full
+	Policy Policy
+
+	// WriteTimeout bounds every write to the client; a client that stops
+	// reading for longer is disconnected
+	WriteTimeout time.Duration
+}
+
+// DefaultConfig is used when no outbox configuration is given
+var DefaultConfig = Config{
+	Size:         256,
+	Policy:       DropOldest,
+	WriteTimeout: 10 * time.Second,
+}
+
+// Outbox is a bounded queue of messages for one connection, drained by its
+// own writer goroutine so that senders never block on a slow client
+type Outbox struct {
+	conn   net.Conn
+	policy Policy
+
+	mu      sync.Mutex
+	queue   chan string
+	closed  bool
+	dropped int
+
+	// limit is a time no write may run past, such as the end of shutdown
+	limit time.Time
+
+	done chan struct{}
+}
+
+// New creates an outbox for conn and starts its writer goroutine
+func New(conn net.Conn, cfg Config) *Outbox {
+	if cfg.Size < 1 {
+		cfg.Size = 1
+	}
+
+	o := &Outbox{
+		conn:   conn,
+		policy: cfg.Policy,
+		queue:  make(chan string, cfg.Size),
+		done:   make(chan struct{}),
+	}
+	go o.writer(cfg.WriteTimeout)
+	return o
+}
+
+// Send queues msg for the client without blocking. It reports false when
+// the outbox is closed or the client was disconnected because it is full.
+func (o *Outbox) Send(msg string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return false
+	}
+
+	select {
+	case o.queue <- msg:
+		return true
+	default:
+	}
+
+	o.dropped++
+	switch o.policy {
+	case DropNewest:
+		return true
+	case DropOldest:
+		select {
+		case <-o.queue:
+		default:
+		}
+		select {
+		case o.queue <- msg:
+		default:
+		}
+		return true
+	default:
+		o.closeLocked()
+		o.conn.Close()
+		return false
+	}
+}
+
+// Limit stops any write to the client from running past t, whatever the
+// write timeout
+func (o *Outbox) Limit(t time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.limit = t
+}
+
+// deadline returns the deadline of a write starting now
+func (o *Outbox) deadline(timeout time.Duration) time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var d time.Time
+	if timeout > 0 {
+		d = time.Now().Add(timeout)
+	}
+	if !o.limit.IsZero() && (d.IsZero() || o.limit.Before(d)) {
+		d = o.limit
+	}
+	return d
+}
+
+// Dropped returns how many messages were discarded because the outbox was full
+func (o *Outbox) Dropped() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.dropped
+}
+
+// Close stops accepting messages and waits for the queued ones to be
+// written, then closes the connection
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	o.closeLocked()
+	o.mu.Unlock()
+
+	<-o.done
+}
+
+// Done is closed once the writer goroutine has exited
+func (o *Outbox) Done() <-chan struct{} {
+	return o.done
+}
+
+func (o *Outbox) closeLocked() {
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+}
+
+// writer drains the queue onto the connection. It is the only writer of
+// the connection, so the deadline it sets before each write is never left
+// over for another. After a failed write the connection is closed and the
+// remaining messages are discarded.
+func (o *Outbox) writer(timeout time.Duration) {
+	defer close(o.done)
+	defer o.conn.Close()
+
+	failed := false
+	for msg := range o.queue {
+		if failed {
+			continue
+		}
+		o.conn.SetWriteDeadline(o.deadline(timeout))
+		if _, err := o.conn.Write([]byte(msg)); err != nil {
+			failed = true
+			o.conn.Close()
+
+			o.mu.Lock()
+			o.closeLocked()
+			o.mu.Unlock()
+		}
+	}
+}
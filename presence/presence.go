@@ -0,0 +1,171 @@
+package presence
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"netcat/models"
+	"netcat/names"
+)
+
+// Info is a snapshot of a connected client, safe to use without the lock
+type Info struct {
+	models.Client
+
+	// Room is the room the client is in
+	Room string
+
+	Operator bool
+}
+
+// DefaultAwayMessage is the away message of /away without one
+const DefaultAwayMessage = "Away"
+
+// IdleMessage is the away message of clients marked away for being idle
+const IdleMessage = "Idle"
+
+// Touch records that conn just sent a line. It reports whether that
+// brought conn back from being marked away for being idle.
+func Touch(hub *models.Hub, conn net.Conn) bool {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	client, ok := hub.Clients[conn]
+	if !ok {
+		return false
+	}
+	client.Active = time.Now()
+	if client.AutoAway {
+		client.Away, client.AutoAway = "", false
+		return true
+	}
+	return false
+}
+
+// MarkIdle marks conn as away if it has sent nothing for the hub's
+// AutoAway period and is not away already. It reports whether it did.
+func MarkIdle(hub *models.Hub, conn net.Conn) bool {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	client, ok := hub.Clients[conn]
+	if !ok || client.Away != "" || hub.AutoAway <= 0 || time.Since(client.Active) < hub.AutoAway {
+		return false
+	}
+	client.Away, client.AutoAway = IdleMessage, true
+	return true
+}
+
+// SetAway marks conn as away with message until it comes back with Back
+func SetAway(hub *models.Hub, conn net.Conn, message string) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	if client, ok := hub.Clients[conn]; ok {
+		client.Away, client.AutoAway = message, false
+	}
+}
+
+// Back clears the away message of conn and reports whether it had one
+func Back(hub *models.Hub, conn net.Conn) bool {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	client, ok := hub.Clients[conn]
+	if !ok || client.Away == "" {
+		return false
+	}
+	client.Away, client.AutoAway = "", false
+	return true
+}
+
+// AwayNotice is what a client is told when it messages or mentions a user
+// who is away
+func AwayNotice(name, message string) string {
+	return fmt.Sprintf("[%s is away: %s]\n", name, message)
+}
+
+// Mentioned returns the away clients other than conn whose name appears in
+// text as a whole word, ignoring case
+func Mentioned(hub *models.Hub, conn net.Conn, text string) []Info {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	var away []Info
+	lower := strings.ToLower(text)
+	for other, client := range hub.Clients {
+		if other != conn && client.Away != "" && mentions(lower, strings.ToLower(client.Name)) {
+			away = append(away, info(hub, other))
+		}
+	}
+	sort.Slice(away, func(i, j int) bool {
+		return strings.ToLower(away[i].Name) < strings.ToLower(away[j].Name)
+	})
+	return away
+}
+
+// mentions reports whether name appears in text with no letter or digit
+// right before or after it
+func mentions(text, name string) bool {
+	for start := 0; ; {
+		i := strings.Index(text[start:], name)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(name)
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !wordRune(before) && !wordRune(after) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+// wordRune reports whether r is part of a word
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// List returns every connected client, sorted by name regardless of case
+func List(hub *models.Hub) []Info {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	list := make([]Info, 0, len(hub.Clients))
+	for conn := range hub.Clients {
+		list = append(list, info(hub, conn))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// Lookup returns the connected client called name, ignoring case
+func Lookup(hub *models.Hub, name string) (Info, bool) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	conn, _, ok := names.Find(hub, name)
+	if !ok {
+		return Info{}, false
+	}
+	return info(hub, conn), true
+}
+
+// info describes the client using conn. The caller must hold hub.Mu.
+func info(hub *models.Hub, conn net.Conn) Info {
+	_, operator := hub.Operators[conn]
+	return Info{
+		Client:   *hub.Clients[conn],
+		Room:     hub.ClientRoom[conn],
+		Operator: operator,
+	}
+}
@@ -0,0 +1,206 @@
+package replay
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"netcat/chatlog"
+	"netcat/history"
+	"netcat/models"
+)
+
+// Filter selects which messages of a log are replayed. Zero fields match
+// everything.
+type Filter struct {
+	// Users keeps messages sent by any of these names, regardless of case.
+	// Renames match both the old and the new name.
+	Users []string
+
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether msg passes the filter
+func (f Filter) Match(msg models.Message) bool {
+	if !f.Since.IsZero() && msg.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !msg.Time.Before(f.Until) {
+		return false
+	}
+	if len(f.Users) == 0 {
+		return true
+	}
+	for _, user := range f.Users {
+		if strings.EqualFold(user, msg.Sender) || strings.EqualFold(user, msg.Meta["old"]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Renderer writes a conversation in one output format
+type Renderer interface {
+	Begin(w io.Writer)
+	Message(w io.Writer, msg models.Message)
+	End(w io.Writer)
+}
+
+// NewRenderer returns the renderer for "text", "markdown" or "html", showing
+// timestamps in loc
+func NewRenderer(format string, loc *time.Location) (Renderer, error) {
+	prefs := models.Prefs{Location: loc}
+	switch format {
+	case "text":
+		return textRenderer{prefs}, nil
+	case "markdown", "md":
+		return markdownRenderer{prefs}, nil
+	case "html":
+		return htmlRenderer{prefs}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (want text, markdown or html)", format)
+}
+
+// Run implements "replay [flags] <log>": it reads a JSON Lines chat log and
+// its rotated segments and writes the conversation to stdout
+func Run(args []string, stdout, stderr io.Writer) error {
+	var format, users, since, until, zone string
+
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: TCPChat replay [flags] <log file>")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&format, "format", "text", "output format: text, markdown or html")
+	fs.StringVar(&users, "user", "", "only messages from these users (comma separated)")
+	fs.StringVar(&since, "since", "", "only messages at or after this time (YYYY-MM-DD[ HH:MM:SS])")
+	fs.StringVar(&until, "until", "", "only messages before this time (YYYY-MM-DD[ HH:MM:SS])")
+	fs.StringVar(&zone, "tz", "Local", "timezone for timestamps and -since/-until")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("replay needs exactly one log file")
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return fmt.Errorf("-tz: %w", err)
+	}
+	renderer, err := NewRenderer(format, loc)
+	if err != nil {
+		return err
+	}
+
+	var filter Filter
+	for _, user := range strings.Split(users, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			filter.Users = append(filter.Users, user)
+		}
+	}
+	if filter.Since, err = parseTime(since, loc); err != nil {
+		return fmt.Errorf("-since: %w", err)
+	}
+	if filter.Until, err = parseTime(until, loc); err != nil {
+		return fmt.Errorf("-until: %w", err)
+	}
+
+	renderer.Begin(stdout)
+	skipped, err := chatlog.Read(fs.Arg(0), func(msg models.Message) error {
+		if filter.Match(msg) {
+			renderer.Message(stdout, msg)
+		}
+		return nil
+	})
+	renderer.End(stdout)
+
+	if skipped > 0 {
+		fmt.Fprintf(stderr, "Skipped %d lines that are not JSON log records (set log_format to json to record them)\n", skipped)
+	}
+	return err
+}
+
+// parseTime reads a timestamp or a date in loc. An empty string is the zero time.
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, history.TimeLayout, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as YYYY-MM-DD[ HH:MM:SS]", value)
+}
+
+// textRenderer writes the lines clients saw
+type textRenderer struct {
+	prefs models.Prefs
+}
+
+func (r textRenderer) Begin(w io.Writer) {}
+
+func (r textRenderer) Message(w io.Writer, msg models.Message) {
+	io.WriteString(w, msg.Render("", r.prefs))
+}
+
+func (r textRenderer) End(w io.Writer) {}
+
+// markdownRenderer writes a bulleted list with names in bold and notices
+// in italics
+type markdownRenderer struct {
+	prefs models.Prefs
+}
+
+func (r markdownRenderer) Begin(w io.Writer) {}
+
+func (r markdownRenderer) Message(w io.Writer, msg models.Message) {
+	if msg.Kind == models.KindChat {
+		fmt.Fprintf(w, "- `%s` **%s**: %s\n", r.prefs.Stamp(msg.Time), escapeMarkdown(msg.Sender), escapeMarkdown(msg.Body))
+		return
+	}
+	notice := strings.TrimSpace(msg.Render("", r.prefs))
+	fmt.Fprintf(w, "- _%s_\n", escapeMarkdown(notice))
+}
+
+func (r markdownRenderer) End(w io.Writer) {}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// htmlRenderer writes a standalone page with one list item per message
+type htmlRenderer struct {
+	prefs models.Prefs
+}
+
+func (r htmlRenderer) Begin(w io.Writer) {
+	io.WriteString(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Chat log</title>\n</head>\n<body>\n<ul class=\"chat-log\">\n")
+}
+
+func (r htmlRenderer) Message(w io.Writer, msg models.Message) {
+	stamp := fmt.Sprintf("<time datetime=\"%s\">%s</time>", msg.Time.UTC().Format(time.RFC3339), html.EscapeString(r.prefs.Stamp(msg.Time)))
+	if msg.Kind == models.KindChat {
+		fmt.Fprintf(w, "<li class=\"chat\">%s <b>%s</b>: %s</li>\n", stamp, html.EscapeString(msg.Sender), html.EscapeString(msg.Body))
+		return
+	}
+	notice := strings.TrimSpace(msg.Render("", r.prefs))
+	fmt.Fprintf(w, "<li class=\"%s\">%s <i>%s</i></li>\n", msg.Kind, stamp, html.EscapeString(notice))
+}
+
+func (r htmlRenderer) End(w io.Writer) {
+	io.WriteString(w, "</ul>\n</body>\n</html>\n")
+}
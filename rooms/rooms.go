@@ -0,0 +1,199 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"netcat/chatlog"
+	"netcat/history"
+	"netcat/models"
+)
+
+// MaxNameLength is the longest room name accepted by Join
+const MaxNameLength = 32
+
+// ErrInvalidName is returned for room names that are empty, too long or
+// contain characters other than letters, digits, '-' and '_'
+var ErrInvalidName = errors.New("invalid room name")
+
+// Normalize strips an optional leading '#' and lowercases a room name
+func Normalize(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+}
+
+// ValidName reports whether name is an acceptable normalized room name
+func ValidName(name string) bool {
+	if name == "" || len(name) > MaxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Join moves conn into the named room, creating it if needed, and returns
+// the room together with the name of the room conn left ("" if none)
+func Join(hub *models.Hub, conn net.Conn, name string) (*models.Room, string, error) {
+	name = Normalize(name)
+	if !ValidName(name) {
+		return nil, "", ErrInvalidName
+	}
+
+	hub.Mu.Lock()
+	_, exists := hub.Rooms[name]
+	hub.Mu.Unlock()
+
+	// A new room reads its history from disk, so it is opened without the lock
+	var opened *models.Room
+	if !exists {
+		room, err := open(hub, name)
+		if err != nil {
+			return nil, "", err
+		}
+		opened = room
+	}
+
+	hub.Mu.Lock()
+	if _, ok := hub.Rooms[name]; !ok && opened != nil {
+		hub.Rooms[name] = opened
+		opened = nil
+	}
+	room, previous, err := enter(hub, conn, name)
+	hub.Mu.Unlock()
+
+	// Someone else created the room in the meantime
+	if opened != nil {
+		CloseLog(hub, opened)
+	}
+	return room, previous, err
+}
+
+// Enter is Join for callers already holding hub.Mu
+func Enter(hub *models.Hub, conn net.Conn, name string) (*models.Room, string, error) {
+	name = Normalize(name)
+	if !ValidName(name) {
+		return nil, "", ErrInvalidName
+	}
+	return enter(hub, conn, name)
+}
+
+// enter moves conn into the room with the normalized name. The caller
+// must hold hub.Mu.
+func enter(hub *models.Hub, conn net.Conn, name string) (*models.Room, string, error) {
+	room, ok := hub.Rooms[name]
+	if !ok {
+		var err error
+		if room, err = open(hub, name); err != nil {
+			return nil, "", err
+		}
+		hub.Rooms[name] = room
+	}
+
+	previous := hub.ClientRoom[conn]
+	if previous == name {
+		return room, previous, nil
+	}
+	if old, ok := hub.Rooms[previous]; ok {
+		delete(old.Members, conn)
+	}
+
+	room.Members[conn] = struct{}{}
+	hub.ClientRoom[conn] = name
+	return room, previous, nil
+}
+
+// open creates the named room, opening its log and loading its history
+func open(hub *models.Hub, name string) (*models.Room, error) {
+	room := models.NewRoom(name)
+	room.History = history.NewBuffer(hub.HistoryBuffer)
+	if hub.OpenRoomLog == nil {
+		return room, nil
+	}
+
+	logFile, historyFile, err := hub.OpenRoomLog(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening log for room %s: %w", name, err)
+	}
+	room.LogFile = logFile
+	room.HistoryFile = historyFile
+	if historyFile != "" {
+		if err := room.History.LoadWith(historyFile, chatlog.HistoryLine); err != nil {
+			CloseLog(hub, room)
+			return nil, fmt.Errorf("loading history for room %s: %w", name, err)
+		}
+	}
+	return room, nil
+}
+
+// Drop removes the named room once its last member has left, unless it is
+// the lobby or a dropped client may still resume into it. It returns the
+// removed room, whose log the caller closes with CloseLog after releasing
+// hub.Mu, or nil if the room stays. The caller must hold hub.Mu.
+func Drop(hub *models.Hub, name string) *models.Room {
+	room, ok := hub.Rooms[name]
+	if !ok || name == models.Lobby || len(room.Members) > 0 {
+		return nil
+	}
+	for _, session := range hub.Detached {
+		if session.Room == name {
+			return nil
+		}
+	}
+	delete(hub.Rooms, name)
+	return room
+}
+
+// CloseLog closes the log of a room that is no longer in use
+func CloseLog(hub *models.Hub, room *models.Room) {
+	if hub.CloseRoomLog != nil && room.LogFile != nil {
+		hub.CloseRoomLog(room.LogFile)
+	}
+}
+
+// Remove takes conn out of its room and returns the name of that room.
+// The caller must hold hub.Mu.
+func Remove(hub *models.Hub, conn net.Conn) string {
+	name := hub.ClientRoom[conn]
+	if room, ok := hub.Rooms[name]; ok {
+		delete(room.Members, conn)
+	}
+	delete(hub.ClientRoom, conn)
+	return name
+}
+
+// Current returns the name of the room conn is in
+func Current(hub *models.Hub, conn net.Conn) string {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	return hub.ClientRoom[conn]
+}
+
+// List returns one line per room, sorted by name, with its member count.
+// The room conn is in is marked.
+func List(hub *models.Hub, conn net.Conn) []string {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	names := make([]string, 0, len(hub.Rooms))
+	for name := range hub.Rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		line := fmt.Sprintf("#%s (%d)", name, len(hub.Rooms[name].Members))
+		if hub.ClientRoom[conn] == name {
+			line += " *"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,101 @@
+package sanitize
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Clean removes terminal escape sequences and control characters from s so
+// that it cannot move the cursor, clear the screen, retitle the window or
+// overwrite earlier lines on another user's terminal. Tabs become spaces
+// and invalid UTF-8 becomes U+FFFD. Colors and other styles are removed
+// too: formatting is only ever added by the server, for clients that ask
+// for it.
+func Clean(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\x1b' || isC1Introducer(r):
+			i += sequenceLength(s[i:])
+			continue
+		case r == utf8.RuneError && size == 1:
+			b.WriteRune(utf8.RuneError)
+		case r == '\t':
+			b.WriteByte(' ')
+		case unicode.IsControl(r):
+			// dropped
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// isC1Introducer reports whether r starts a sequence on its own, as the
+// 8-bit forms of ESC [, ESC ], ESC P, ESC X, ESC ^ and ESC _ do
+func isC1Introducer(r rune) bool {
+	switch r {
+	case '\u009b', '\u009d', '\u0090', '\u0098', '\u009e', '\u009f':
+		return true
+	}
+	return false
+}
+
+// sequenceLength returns how many bytes of s, which starts with ESC or a
+// C1 introducer, belong to the escape sequence. An unterminated sequence
+// runs to the end of s.
+func sequenceLength(s string) int {
+	r, size := utf8.DecodeRuneInString(s)
+
+	kind := r
+	if r == '\x1b' {
+		if len(s) == size {
+			return size
+		}
+		kind = rune(s[size])
+		size++
+	}
+
+	switch kind {
+	case '[', '\u009b':
+		// CSI: parameter and intermediate bytes, then one final byte
+		n := size
+		for n < len(s) && s[n] >= 0x20 && s[n] <= 0x3f {
+			n++
+		}
+		for n < len(s) && s[n] >= 0x20 && s[n] <= 0x2f {
+			n++
+		}
+		if n < len(s) && s[n] >= 0x40 && s[n] <= 0x7e {
+			n++
+		}
+		return n
+	case ']', 'P', 'X', '^', '_', '\u009d', '\u0090', '\u0098', '\u009e', '\u009f':
+		// OSC and other strings, ended by BEL or a string terminator
+		for n := size; n < len(s); {
+			r, rsize := utf8.DecodeRuneInString(s[n:])
+			switch {
+			case r == '\a' || r == '\u009c':
+				return n + rsize
+			case r == '\x1b' && n+1 < len(s) && s[n+1] == '\\':
+				return n + 2
+			}
+			n += rsize
+		}
+		return len(s)
+	}
+
+	// Any other escape: intermediate bytes, then one final byte. ESC
+	// followed by anything else is dropped alone.
+	n := size - 1
+	for n < len(s) && s[n] >= 0x20 && s[n] <= 0x2f {
+		n++
+	}
+	if n < len(s) && s[n] >= 0x30 && s[n] <= 0x7e {
+		n++
+	}
+	return n
+}
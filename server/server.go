@@ -1,50 +1,754 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"netcat/accounts"
+	"netcat/bans"
 	"netcat/broadcast"
+	"netcat/chatlog"
 	"netcat/client"
+	"netcat/flood"
+	"netcat/history"
+	"netcat/input"
+	"netcat/logfile"
 	"netcat/models"
+	"netcat/moderation"
+	"netcat/outbox"
+	"netcat/sessions"
+	"netcat/utils"
 )
 
-// StartServer initializes the TCP chat server
-func InitServer(port string) error {
-	ln, err := net.Listen("tcp", port)
+const (
+	// DefaultMaxClients is the number of named clients a server accepts by default
+	DefaultMaxClients = 10
+
+	// DefaultShutdownGrace is how long clients are warned before Shutdown disconnects them
+	DefaultShutdownGrace = 5 * time.Second
+
+	// DefaultShutdownNotice is broadcast when Shutdown starts; %d is the grace period in seconds
+	DefaultShutdownNotice = "Server is shutting down in %d seconds...\n"
+
+	// DefaultCloseTimeout bounds the final writes to each client during Shutdown
+	DefaultCloseTimeout = 2 * time.Second
+
+	// handshakeTimeout bounds the TLS handshake of a new connection
+	handshakeTimeout = 10 * time.Second
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown
+var ErrServerClosed = errors.New("server: server closed")
+
+// Option configures a Server created by New
+type Option func(*Server)
+
+// WithLogDir sets the directory the chat log is written to
+func WithLogDir(dir string) Option {
+	return func(s *Server) {
+		s.logDir = dir
+	}
+}
+
+// WithLogName sets the name the log files are called after, in place of
+// the port of the first listener. Servers with several listeners set it so
+// the names do not depend on which listener starts first.
+func WithLogName(name string) Option {
+	return func(s *Server) {
+		s.logName = name
+	}
+}
+
+// WithLogRotation sets when the log files are rotated and how much history
+// is kept across restarts
+func WithLogRotation(policy logfile.Policy) Option {
+	return func(s *Server) {
+		s.logPolicy = policy
+	}
+}
+
+// WithLogWriter sends the chat log to w instead of a file in the log directory
+func WithLogWriter(w io.Writer) Option {
+	return func(s *Server) {
+		s.logWriter = w
+	}
+}
+
+// WithLogFormat sets whether the chat log holds plain text lines or JSON
+// records of every event
+func WithLogFormat(f chatlog.Format) Option {
+	return func(s *Server) {
+		s.hub.EncodeLog = chatlog.Encoder(f)
+	}
+}
+
+// WithMaxClients sets how many clients may be connected at once, counting
+// those still choosing a name
+func WithMaxClients(n int) Option {
+	return func(s *Server) {
+		s.maxClients = n
+	}
+}
+
+// WithWaitQueue lets up to n connections wait in line for a free slot
+// instead of being turned away when the server is full
+func WithWaitQueue(n int) Option {
+	return func(s *Server) {
+		s.waitQueue = n
+	}
+}
+
+// WithAdminSlot keeps one slot above the client limit for connections from
+// the given hosts, so an administrator can always get in
+func WithAdminSlot(hosts ...string) Option {
+	return func(s *Server) {
+		s.adminHosts = hosts
+	}
+}
+
+// WithBanner sets the file shown to new connections before the name prompt
+func WithBanner(path string) Option {
+	return func(s *Server) {
+		s.hub.BannerFile = path
+	}
+}
+
+// WithHistoryLines limits how many lines of history joining clients receive
+func WithHistoryLines(n int) Option {
+	return func(s *Server) {
+		s.hub.HistoryLines = n
+	}
+}
+
+// WithHistoryBuffer sets how many lines of history each room keeps in
+// memory for replay and /history
+func WithHistoryBuffer(n int) Option {
+	return func(s *Server) {
+		s.hub.HistoryBuffer = n
+	}
+}
+
+// WithMaxLine sets the longest line a client may send, in bytes, and
+// whether longer lines are cut to fit or refused
+func WithMaxLine(n int, policy input.Policy) Option {
+	return func(s *Server) {
+		s.hub.MaxLine = n
+		s.hub.Oversize = policy
+	}
+}
+
+// WithNameTimeout closes connections that have not picked a name within d.
+// Zero lets them wait forever.
+func WithNameTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.hub.NameTimeout = d
+	}
+}
+
+// WithResumeGrace keeps the session of a dropped connection for d, so
+// the client can reconnect with its resume token without losing its name,
+// room or the messages sent meanwhile. Zero turns resuming off.
+func WithResumeGrace(d time.Duration) Option {
+	return func(s *Server) {
+		s.hub.ResumeGrace = d
+	}
+}
+
+// WithAutoAway marks users as away once they have sent nothing for d;
+// zero leaves it to /away
+func WithAutoAway(d time.Duration) Option {
+	return func(s *Server) {
+		s.hub.AutoAway = d
+	}
+}
+
+// WithRenameCooldown makes users wait d between renames
+func WithRenameCooldown(d time.Duration) Option {
+	return func(s *Server) {
+		s.hub.RenameCooldown = d
+	}
+}
+
+// WithFeatures switches optional chat features on or off
+func WithFeatures(f models.Features) Option {
+	return func(s *Server) {
+		s.hub.Features = f
+	}
+}
+
+// WithNameRules sets the rules usernames must follow
+func WithNameRules(rules models.NameRules) Option {
+	return func(s *Server) {
+		s.hub.NameRules = rules
+	}
+}
+
+// WithOutbox sets the size, overflow policy and write timeout of every
+// client's outbound queue
+func WithOutbox(cfg outbox.Config) Option {
+	return func(s *Server) {
+		s.hub.Outbox = cfg
+	}
+}
+
+// WithFlood sets how fast clients may send and how those sending faster
+// are dealt with
+func WithFlood(cfg flood.Config) Option {
+	return func(s *Server) {
+		s.hub.Flood = flood.New(cfg)
+	}
+}
+
+// WithShutdownGrace sets how long clients keep chatting after the shutdown
+// notice before they are disconnected
+func WithShutdownGrace(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownGrace = d
+	}
+}
+
+// WithShutdownNotice sets the message broadcast when Shutdown starts. The
+// format receives the grace period in whole seconds.
+func WithShutdownNotice(format string) Option {
+	return func(s *Server) {
+		s.shutdownNotice = format
+	}
+}
+
+// WithOpHosts makes every connection from the given hosts an operator
+func WithOpHosts(hosts ...string) Option {
+	return func(s *Server) {
+		s.opHosts = hosts
+	}
+}
+
+// WithOpPassword lets users become operators with "/op <password>"
+func WithOpPassword(password string) Option {
+	return func(s *Server) {
+		s.hub.OpPassword = password
+	}
+}
+
+// WithBanFile keeps the ban list in the given file so bans survive
+// restarts. Without it bans last until the server stops.
+func WithBanFile(path string) Option {
+	return func(s *Server) {
+		s.banFile = path
+	}
+}
+
+// WithAccountFile keeps the registered accounts in the given file. Without
+// it accounts last until the server stops.
+func WithAccountFile(path string) Option {
+	return func(s *Server) {
+		s.accountFile = path
+	}
+}
+
+// WithCloseTimeout bounds how long Shutdown waits on writes to a client
+// before closing its connection
+func WithCloseTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.closeTimeout = d
+	}
+}
+
+// Server is a TCP chat server. Each Server owns its own clients, broadcast
+// channel and log, so several can run side by side in one process.
+type Server struct {
+	hub         *models.Hub
+	logDir      string
+	logName     string
+	logWriter   io.Writer
+	logPolicy   logfile.Policy
+	maxClients  int
+	waitQueue   int
+	adminHosts  []string
+	opHosts     []string
+	banFile     string
+	accountFile string
+	slots       *slots
+
+	shutdownGrace  time.Duration
+	shutdownNotice string
+	closeTimeout   time.Duration
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	logFiles  []*logfile.File
+	conns     map[net.Conn]struct{}
+	handlers  sync.WaitGroup
+	started   bool
+	closed    bool
+
+	startOnce sync.Once
+	startErr  error
+
+	broadcastDone chan struct{}
+	stopOnce      sync.Once
+	stopped       chan struct{}
+}
+
+// New creates a Server configured by the given options
+func New(opts ...Option) *Server {
+	s := &Server{
+		hub:        models.NewHub(),
+		logDir:     "logs",
+		maxClients: DefaultMaxClients,
+
+		shutdownGrace:  DefaultShutdownGrace,
+		shutdownNotice: DefaultShutdownNotice,
+		closeTimeout:   DefaultCloseTimeout,
+
+		conns:         make(map[net.Conn]struct{}),
+		broadcastDone: make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.slots = &slots{
+		max:        s.maxClients,
+		queueLimit: s.waitQueue,
+		adminSlot:  len(s.adminHosts) > 0,
+	}
+	return s
+}
+
+// Hub returns the shared state of the server
+func (s *Server) Hub() *models.Hub {
+	return s.hub
+}
+
+// Addr returns the address of the first listener the server serves, or
+// nil before Serve
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of every listener the server serves
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, ln := range s.listeners {
+		addrs[i] = ln.Addr()
+	}
+	return addrs
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients on it
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	defer ln.Close()
 
-	fmt.Println("Listening on the port " + port)
+	fmt.Println("Listening on the port " + addr)
 
-	portnum := port[1:]
-	logfileName := fmt.Sprintf("logs/chat_log_%s.log", portnum)
+	return s.Serve(ln)
+}
 
-	models.LogFile, err = os.OpenFile(logfileName, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0o644)
+// ListenAndServeTLS listens on the TCP address addr and serves clients on
+// it over TLS
+func (s *Server) ListenAndServeTLS(addr string, cfg *tls.Config) error {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	defer models.LogFile.Close()
 
-	go broadcast.Broadcaster()
+	fmt.Println("Listening for TLS on the port " + addr)
+
+	return s.ServeTLS(ln, cfg)
+}
+
+// ServeTLS accepts connections on ln and serves them over TLS until
+// Shutdown is called
+func (s *Server) ServeTLS(ln net.Listener, cfg *tls.Config) error {
+	return s.Serve(tls.NewListener(ln, cfg))
+}
+
+// Serve accepts connections on ln until Shutdown is called. It may be
+// called for several listeners, such as a plain and a TLS one, which then
+// share the same clients, rooms and logs. The logs are named after the
+// port of the first listener unless WithLogName is set.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.started = true
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+	defer ln.Close()
+
+	if err := s.start(ln.Addr()); err != nil {
+		return err
+	}
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 
-		if len(models.Clients) >= 10 {
-			conn.Write([]byte("Chatroom full...\n"))
+		if !s.trackConn(conn) {
 			conn.Close()
-			continue
+			return ErrServerClosed
+		}
+
+		go s.admit(conn)
+	}
+}
+
+// start loads the ban list and accounts, opens the logs and starts the broadcaster. It
+// runs once, for the first listener; Serve calls for other listeners wait
+// for it to finish.
+func (s *Server) start(addr net.Addr) error {
+	s.startOnce.Do(func() {
+		if s.banFile != "" {
+			list, err := bans.Open(s.banFile)
+			if err != nil {
+				s.startErr = fmt.Errorf("loading bans: %w", err)
+				close(s.broadcastDone)
+				return
+			}
+			s.hub.Bans = list
+		}
+		if s.accountFile != "" {
+			store, err := accounts.Open(s.accountFile)
+			if err != nil {
+				s.startErr = fmt.Errorf("loading accounts: %w", err)
+				close(s.broadcastDone)
+				return
+			}
+			s.hub.Accounts = store
+		}
+
+		if err := s.openLog(addr); err != nil {
+			s.startErr = err
+			close(s.broadcastDone)
+			return
+		}
+
+		go func() {
+			broadcast.Broadcaster(s.hub)
+			close(s.broadcastDone)
+		}()
+	})
+	return s.startErr
+}
+
+// admit completes the TLS handshake of a new connection, turns it away if
+// its address is banned and otherwise gives it a client slot
+func (s *Server) admit(conn net.Conn) {
+	if err := handshake(conn); err != nil {
+		conn.Close()
+		s.untrackConn(conn)
+		return
+	}
+
+	if entry, banned := s.hub.Bans.IP(moderation.Host(conn.RemoteAddr())); banned {
+		s.slots.notify(conn, moderation.BanNotice(entry))
+		conn.Close()
+		s.untrackConn(conn)
+		return
+	}
+
+	result, release := s.slots.reserve(conn, s.isAdminHost(conn.RemoteAddr()))
+	switch result {
+	case slotGranted:
+		s.handle(conn, release)
+	case slotFull:
+		s.slots.notify(conn, "Chatroom full...\n")
+		conn.Close()
+		s.untrackConn(conn)
+	}
+	// Queued connections are started by handle when a slot frees up
+}
+
+// handshake completes the TLS handshake of conn, if it is a TLS
+// connection, so a client that never finishes it cannot hold a slot
+func handshake(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	return tlsConn.HandshakeContext(ctx)
+}
+
+// handle serves conn, then passes its slot on to the next connection
+// waiting in line
+func (s *Server) handle(conn net.Conn, release func() net.Conn) {
+	if s.isOpHost(conn.RemoteAddr()) {
+		moderation.Grant(s.hub, conn)
+	}
+	client.HandleClient(s.hub, conn)
+	s.untrackConn(conn)
+
+	if next := release(); next != nil {
+		go s.handle(next, release)
+	}
+}
+
+// isAdminHost reports whether addr may use the admin slot
+func (s *Server) isAdminHost(addr net.Addr) bool {
+	return hasHost(s.adminHosts, addr)
+}
+
+// isOpHost reports whether connections from addr are operators
+func (s *Server) isOpHost(addr net.Addr) bool {
+	return hasHost(s.opHosts, addr)
+}
+
+// hasHost reports whether the host of addr is one of hosts
+func hasHost(hosts []string, addr net.Addr) bool {
+	host := moderation.Host(addr)
+	for _, h := range hosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// Shutdown stops accepting connections, broadcasts the shutdown notice and
+// lets clients keep chatting for the grace period. It then disconnects every
+// client, drains the broadcast channel, and flushes and closes the log. If
+// ctx expires first the grace period is cut short, its error is returned and
+// the remaining work continues in the background.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		started := s.started
+		for _, ln := range s.listeners {
+			ln.Close()
+		}
+		s.mu.Unlock()
+
+		go s.drain(ctx, started)
+	})
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain performs the shutdown sequence started by Shutdown
+func (s *Server) drain(ctx context.Context, started bool) {
+	// No write to a client may outlive the grace period by more than closeTimeout
+	s.mu.Lock()
+	deadline := time.Now().Add(s.shutdownGrace + s.closeTimeout)
+	for conn := range s.conns {
+		conn.SetWriteDeadline(deadline)
+	}
+	s.mu.Unlock()
+	s.slots.limitWrites(deadline)
+	s.hub.Mu.Lock()
+	for _, box := range s.hub.Outboxes {
+		box.Limit(deadline)
+	}
+	s.hub.Mu.Unlock()
+
+	if started {
+		notice := s.shutdownNotice
+		if strings.Contains(notice, "%") {
+			notice = fmt.Sprintf(notice, int(s.shutdownGrace.Round(time.Second)/time.Second))
+		}
+		if !strings.HasSuffix(notice, "\n") {
+			notice += "\n"
+		}
+
+		// The notice only concerns the clients connected now, so it skips
+		// the broadcaster and is neither logged nor replayed after a restart
+		s.hub.Mu.Lock()
+		for conn := range s.hub.Clients {
+			utils.Deliver(s.hub, conn, notice)
+		}
+		s.hub.Mu.Unlock()
+
+		select {
+		case <-time.After(s.shutdownGrace):
+		case <-ctx.Done():
 		}
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	// Connections still waiting for a slot will never be handled
+	for _, conn := range s.slots.drain() {
+		s.untrackConn(conn)
+	}
 
-		go client.HandleClient(conn, logfileName)
+	s.handlers.Wait()
+	if started {
+		sessions.Close(s.hub)
+		close(s.hub.Broadcast)
+		<-s.broadcastDone
 	}
+	s.closeLog()
+	close(s.stopped)
+}
+
+// openLog sets up logging for every room: all rooms share the configured
+// log writer, or each gets its own file in the log directory. The lobby
+// keeps the <logDir>/chat_log_<name>.log name, where name is set by
+// WithLogName or is the port of addr. The file of a removed room is closed
+// by CloseRoomLog.
+func (s *Server) openLog(addr net.Addr) error {
+	if s.logWriter != nil {
+		s.hub.OpenRoomLog = func(room string) (io.Writer, string, error) {
+			return s.logWriter, "", nil
+		}
+	} else {
+		logName := s.logName
+		if logName == "" {
+			logName = addr.String()
+			if _, port, err := net.SplitHostPort(logName); err == nil {
+				logName = port
+			}
+		}
+
+		if err := os.MkdirAll(s.logDir, 0o755); err != nil {
+			return err
+		}
+
+		s.hub.OpenRoomLog = func(room string) (io.Writer, string, error) {
+			logfileName := filepath.Join(s.logDir, fmt.Sprintf("chat_log_%s.log", logName))
+			if room != models.Lobby {
+				logfileName = filepath.Join(s.logDir, fmt.Sprintf("chat_log_%s_%s.log", logName, room))
+			}
+
+			file, err := logfile.Open(logfileName, s.logPolicy)
+			if err != nil {
+				return nil, "", err
+			}
+
+			s.mu.Lock()
+			s.logFiles = append(s.logFiles, file)
+			s.mu.Unlock()
+
+			return file, logfileName, nil
+		}
+		s.hub.CloseRoomLog = func(w io.Writer) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			for i, file := range s.logFiles {
+				if file != w {
+					continue
+				}
+				if err := file.Sync(); err != nil {
+					log.Printf("Error syncing log file: %v", err)
+				}
+				file.Close()
+				s.logFiles = append(s.logFiles[:i], s.logFiles[i+1:]...)
+				return
+			}
+		}
+	}
+
+	logFile, historyFile, err := s.hub.OpenRoomLog(models.Lobby)
+	if err != nil {
+		return err
+	}
+
+	lobbyHistory := history.NewBuffer(s.hub.HistoryBuffer)
+	if historyFile != "" {
+		if err := lobbyHistory.LoadWith(historyFile, chatlog.HistoryLine); err != nil {
+			return err
+		}
+	}
+
+	s.hub.Mu.Lock()
+	lobby := s.hub.Rooms[models.Lobby]
+	lobby.LogFile = logFile
+	lobby.HistoryFile = historyFile
+	lobby.History = lobbyHistory
+	s.hub.Mu.Unlock()
+	return nil
+}
+
+// closeLog flushes the log writer and closes the files opened by openLog
+func (s *Server) closeLog() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.logWriter.(interface{ Flush() error }); ok {
+		if err := w.Flush(); err != nil {
+			log.Printf("Error flushing log file: %v", err)
+		}
+	}
+
+	for _, file := range s.logFiles {
+		if err := file.Sync(); err != nil {
+			log.Printf("Error syncing log file: %v", err)
+		}
+		file.Close()
+	}
+	s.logFiles = nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// trackConn registers a connection so Shutdown can close it. It reports
+// false once the server is shutting down.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.handlers.Done()
 }
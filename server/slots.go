@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// queueNoticeTimeout bounds writes to connections waiting in line
+const queueNoticeTimeout = time.Second
+
+// slotResult is the outcome of asking for a client slot
+type slotResult int
+
+const (
+	slotGranted slotResult = iota
+	slotQueued
+	slotFull
+)
+
+// slots limits how many connections are served at once. A connection holds
+// its slot from the moment it is accepted, including while it is still
+// choosing a name. When every slot is taken, connections may wait in line
+// and one extra slot can be kept for administrators.
+type slots struct {
+	mu         sync.Mutex
+	max        int
+	used       int
+	adminSlot  bool
+	adminUsed  bool
+	queueLimit int
+	queue      []net.Conn
+
+	// limit is a time no write to a waiting connection may run past, set
+	// when the server shuts down
+	limit time.Time
+}
+
+// reserve claims a slot for conn, an admin slot when the regular ones are
+// taken and admin is true, or a place in the waiting line
+func (sl *slots) reserve(conn net.Conn, admin bool) (slotResult, func() net.Conn) {
+	sl.mu.Lock()
+	if sl.used < sl.max {
+		sl.used++
+		sl.mu.Unlock()
+		return slotGranted, sl.releaseRegular
+	}
+	if admin && sl.adminSlot && !sl.adminUsed {
+		sl.adminUsed = true
+		sl.mu.Unlock()
+		return slotGranted, sl.releaseAdmin
+	}
+	if len(sl.queue) < sl.queueLimit {
+		sl.queue = append(sl.queue, conn)
+		position := len(sl.queue)
+		sl.mu.Unlock()
+
+		sl.notify(conn, fmt.Sprintf("Chatroom full... You are #%d in line.\n", position))
+		return slotQueued, nil
+	}
+	sl.mu.Unlock()
+	return slotFull, nil
+}
+
+// releaseRegular frees a regular slot. If someone is waiting the slot passes
+// straight to them and their connection is returned.
+func (sl *slots) releaseRegular() net.Conn {
+	sl.mu.Lock()
+	if len(sl.queue) == 0 {
+		sl.used--
+		sl.mu.Unlock()
+		return nil
+	}
+
+	next := sl.queue[0]
+	sl.queue = sl.queue[1:]
+	waiting := append([]net.Conn(nil), sl.queue...)
+	sl.mu.Unlock()
+
+	sl.announcePositions(waiting)
+	return next
+}
+
+// releaseAdmin frees the admin slot
+func (sl *slots) releaseAdmin() net.Conn {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.adminUsed = false
+	return nil
+}
+
+// drain empties the waiting line and returns the connections that were in it
+func (sl *slots) drain() []net.Conn {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	waiting := sl.queue
+	sl.queue = nil
+	return waiting
+}
+
+// limitWrites stops notices to waiting connections from running past t
+func (sl *slots) limitWrites(t time.Time) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.limit = t
+}
+
+// announcePositions tells each connection in waiting where it stands. It must be
+// called without holding sl.mu, so a stuck client cannot hold up the slots.
+func (sl *slots) announcePositions(waiting []net.Conn) {
+	for i, conn := range waiting {
+		sl.notify(conn, fmt.Sprintf("You are #%d in line.\n", i+1))
+	}
+}
+
+// notify writes a message to a connection that is not being served yet
+// without letting a stuck client hold up the line. The deadline set during
+// shutdown, if any, is put back afterwards.
+func (sl *slots) notify(conn net.Conn, msg string) {
+	sl.mu.Lock()
+	limit := sl.limit
+	sl.mu.Unlock()
+
+	deadline := time.Now().Add(queueNoticeTimeout)
+	if !limit.IsZero() && limit.Before(deadline) {
+		deadline = limit
+	}
+	conn.SetWriteDeadline(deadline)
+	conn.Write([]byte(msg))
+	conn.SetWriteDeadline(limit)
+}
@@ -0,0 +1,186 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"netcat/models"
+	"netcat/rooms"
+)
+
+// tokenSize is the number of random bytes in a resume token
+const tokenSize = 16
+
+// ErrUnknownToken is returned by Resume for a token that matches no waiting
+// session, because it is wrong or its grace period is over
+var ErrUnknownToken = errors.New("unknown or expired resume token")
+
+// Issue gives conn a new resume token and returns it. It returns "" when
+// sessions cannot be resumed on this hub.
+func Issue(hub *models.Hub, conn net.Conn) string {
+	if hub.ResumeGrace <= 0 {
+		return ""
+	}
+
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	token := hex.EncodeToString(b)
+
+	hub.Mu.Lock()
+	hub.Tokens[conn] = token
+	hub.Mu.Unlock()
+	return token
+}
+
+// Revoke forgets the token of conn, so its session ends with the
+// connection. Clients that are kicked or banned lose their token this
+// way. The caller must hold hub.Mu.
+func Revoke(hub *models.Hub, conn net.Conn) {
+	delete(hub.Tokens, conn)
+}
+
+// Detach removes conn from the hub but keeps its session waiting for the
+// grace period, reserving its name and collecting the messages it misses.
+// It reports false when conn has no token or was already removed; the
+// caller then announces the departure as usual.
+func Detach(hub *models.Hub, conn net.Conn, account string) bool {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	token, ok := hub.Tokens[conn]
+	delete(hub.Tokens, conn)
+	client, named := hub.Clients[conn]
+	if !ok || !named {
+		return false
+	}
+
+	_, operator := hub.Operators[conn]
+
+	// Coming back is activity, so only an away message set by hand is kept
+	away := client.Away
+	if client.AutoAway {
+		away = ""
+	}
+	session := &models.Session{
+		Token:    token,
+		Name:     client.Name,
+		Account:  account,
+		Room:     rooms.Remove(hub, conn),
+		Prefs:    hub.Prefs[conn],
+		Operator: operator,
+		ReplyTo:  hub.ReplyTo[conn],
+		Away:     away,
+		Joined:   client.Joined,
+		Since:    time.Now(),
+	}
+	session.Expiry = time.AfterFunc(hub.ResumeGrace, func() { expire(hub, token) })
+	hub.Detached[token] = session
+
+	delete(hub.Clients, conn)
+	delete(hub.ReplyTo, conn)
+	delete(hub.Prefs, conn)
+	delete(hub.Operators, conn)
+	return true
+}
+
+// Resume hands the session waiting under token to conn: the name,
+// settings, operator role and reply target are restored and conn is put
+// back in its room. The session is returned so the caller can deliver what
+// it missed before anything new arrives. The caller must hold hub.Mu.
+func Resume(hub *models.Hub, conn net.Conn, token string) (*models.Session, error) {
+	session, ok := hub.Detached[token]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+	if _, _, err := rooms.Enter(hub, conn, session.Room); err != nil {
+		return nil, err
+	}
+	delete(hub.Detached, token)
+	session.Expiry.Stop()
+
+	client := models.NewClient(conn, session.Name)
+	client.Joined = session.Joined
+	client.Away = session.Away
+	hub.Clients[conn] = client
+	hub.Prefs[conn] = session.Prefs
+	if session.Operator {
+		hub.Operators[conn] = struct{}{}
+	}
+	if session.ReplyTo != "" {
+		hub.ReplyTo[conn] = session.ReplyTo
+	}
+	return session, nil
+}
+
+// Find returns the waiting session of the user called name, ignoring case.
+// The caller must hold hub.Mu.
+func Find(hub *models.Hub, name string) (*models.Session, bool) {
+	for _, session := range hub.Detached {
+		if strings.EqualFold(session.Name, name) {
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+// Record keeps msg for every session waiting in room. The caller must hold
+// hub.Mu.
+func Record(hub *models.Hub, room string, msg models.Message) {
+	for _, session := range hub.Detached {
+		if session.Room == room {
+			Keep(hub, session, msg)
+		}
+	}
+}
+
+// Keep adds msg to what session missed, dropping the oldest message once
+// the backlog is full. The caller must hold hub.Mu.
+func Keep(hub *models.Hub, session *models.Session, msg models.Message) {
+	if hub.ResumeBacklog > 0 && len(session.Missed) >= hub.ResumeBacklog {
+		session.Missed = session.Missed[1:]
+		session.Dropped++
+	}
+	session.Missed = append(session.Missed, msg)
+}
+
+// Close ends every waiting session at once, announcing the departures, and
+// waits for departures already being announced. Servers call it before
+// closing the broadcast channel.
+func Close(hub *models.Hub) {
+	hub.Mu.Lock()
+	waiting := make([]*models.Session, 0, len(hub.Detached))
+	for token, session := range hub.Detached {
+		session.Expiry.Stop()
+		delete(hub.Detached, token)
+		waiting = append(waiting, session)
+	}
+	hub.Mu.Unlock()
+
+	for _, session := range waiting {
+		hub.Broadcast <- models.NewMessage(models.KindLeave, session.Name, session.Room, "")
+	}
+	hub.Expiring.Wait()
+}
+
+// expire ends the session waiting under token once its grace period is over
+func expire(hub *models.Hub, token string) {
+	hub.Mu.Lock()
+	session, ok := hub.Detached[token]
+	if ok {
+		delete(hub.Detached, token)
+		hub.Expiring.Add(1)
+	}
+	hub.Mu.Unlock()
+	if !ok {
+		return
+	}
+
+	defer hub.Expiring.Done()
+	hub.Broadcast <- models.NewMessage(models.KindLeave, session.Name, session.Room, "")
+}
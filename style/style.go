@@ -0,0 +1,94 @@
+package style
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// palette holds the foreground colors names are drawn in. Black, white and
+// grey are left out so every name stands out on dark and light terminals.
+var palette = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
+// markers maps each markup character to the SGR codes turning its style
+// on and off
+var markers = map[byte][2]string{
+	'*': {"\x1b[1m", "\x1b[22m"},
+	'_': {"\x1b[3m", "\x1b[23m"},
+}
+
+// Color returns the SGR foreground code of name. It depends only on the
+// name, ignoring case, so a user keeps their color across sessions.
+func Color(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(name)))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+// Name returns name drawn in its color
+func Name(name string) string {
+	if name == "" {
+		return name
+	}
+	return "\x1b[" + strconv.Itoa(Color(name)) + "m" + name + "\x1b[39m"
+}
+
+// Markup renders *bold* and _italic_ spans of text as ANSI styles. A
+// marker only opens at the start of a word and closes at the end of one,
+// so snake_case names and 2*3*4 are left alone.
+func Markup(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		codes, ok := markers[text[i]]
+		if ok && opens(text, i) {
+			if end := closes(text, i); end > 0 {
+				b.WriteString(codes[0])
+				b.WriteString(Markup(text[i+1 : end]))
+				b.WriteString(codes[1])
+				i = end + 1
+				continue
+			}
+		}
+		b.WriteByte(text[i])
+		i++
+	}
+	return b.String()
+}
+
+// opens reports whether the marker at i can start a span: it follows the
+// start of the text or a non-word character and precedes a non-space
+func opens(text string, i int) bool {
+	if i+1 >= len(text) || text[i+1] == ' ' || text[i+1] == text[i] {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return !isWord(r)
+}
+
+// closes returns the index of the marker closing the span opened at i, or
+// -1 if there is none
+func closes(text string, i int) int {
+	marker := text[i]
+	for j := i + 2; j < len(text); j++ {
+		if text[j] != marker || text[j-1] == ' ' {
+			continue
+		}
+		if j+1 == len(text) {
+			return j
+		}
+		if r, _ := utf8.DecodeRuneInString(text[j+1:]); !isWord(r) {
+			return j
+		}
+	}
+	return -1
+}
+
+// isWord reports whether r can be part of a word
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '*' || r == '_'
+}
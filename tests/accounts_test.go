@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/accounts"
+	"netcat/models"
+)
+
+func TestPasswordHash(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA256
+	vectors := []struct {
+		password, salt string
+		iterations     int
+		key            string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+	}
+	encode := base64.RawStdEncoding.EncodeToString
+	for _, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		hash := strings.Join([]string{"pbkdf2-sha256", strconv.Itoa(v.iterations), encode([]byte(v.salt)), encode(key)}, "$")
+		if !accounts.Check(hash, v.password) {
+			t.Errorf("Expected %q with %d iterations to match its test vector", v.password, v.iterations)
+		}
+	}
+
+	hash, err := accounts.Hash("correct horse", 10)
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if !accounts.Check(hash, "correct horse") || accounts.Check(hash, "correct horsE") {
+		t.Errorf("Expected only the right password to match %q", hash)
+	}
+	if again, _ := accounts.Hash("correct horse", 10); again == hash {
+		t.Error("Expected every hash to use a new salt")
+	}
+	if accounts.Check("plain text", "plain text") {
+		t.Error("Expected a malformed hash never to match")
+	}
+}
+
+func TestAccountStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	store, err := accounts.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	store.Iterations = 10
+
+	if err := store.Register("Alice", "short"); !errors.Is(err, accounts.ErrShortPassword) {
+		t.Errorf("Expected a short password to be refused, got %v", err)
+	}
+	if err := store.Register("Alice", "wonderland"); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := store.Register("alice", "looking-glass"); !errors.Is(err, accounts.ErrRegistered) {
+		t.Errorf("Expected names to be registered regardless of case, got %v", err)
+	}
+	if err := store.ChangePassword("alice", "wrong guess", "looking-glass"); !errors.Is(err, accounts.ErrWrongPassword) {
+		t.Errorf("Expected the old password to be checked, got %v", err)
+	}
+	if err := store.ChangePassword("alice", "wonderland", "looking-glass"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the account file: %v", err)
+	}
+	if strings.Contains(string(data), "looking-glass") || strings.Contains(string(data), "wonderland") {
+		t.Error("Expected no password to be stored in the clear")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the account file to be private, got %v (err: %v)", info.Mode(), err)
+	}
+
+	reopened, err := accounts.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if !reopened.Registered("ALICE") {
+		t.Error("Expected the account to survive a restart")
+	}
+	if err := reopened.Verify("alice", "looking-glass"); err != nil {
+		t.Errorf("Expected the new password to work, got %v", err)
+	}
+	if err := reopened.Verify("alice", "wonderland"); !errors.Is(err, accounts.ErrWrongPassword) {
+		t.Errorf("Expected the old password to be rejected, got %v", err)
+	}
+	if err := reopened.Verify("bob", "anything"); !errors.Is(err, accounts.ErrNoAccount) {
+		t.Errorf("Expected ErrNoAccount, got %v", err)
+	}
+}
+
+func TestRegisteredNameLogin(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.Accounts.Iterations = 10
+
+	// An unregistered name joins without a password and can register it
+	alice, aliceReader, _ := joinTestClient(t, hub, "alice")
+	defer alice.Close()
+	alice.Write([]byte("/register pw\n"))
+	readUntil(t, aliceReader, "Password must be at least 8 characters.")
+	alice.Write([]byte("/register white rabbit\n"))
+	readUntil(t, aliceReader, "Usage: /register <password>")
+	alice.Write([]byte("/register white-rabbit\n"))
+	readUntil(t, aliceReader, "Your name alice is now registered.")
+	alice.Write([]byte("/register white-rabbit\n"))
+	readUntil(t, aliceReader, "Name is already registered.")
+	if err := hub.Accounts.Verify("alice", "white-rabbit"); err != nil {
+		t.Fatalf("Expected the password to be registered, got %v", err)
+	}
+
+	// Nobody else may take the name, even with /rename
+	bob, bobReader, _ := joinTestClient(t, hub, "bob")
+	defer bob.Close()
+	bob.Write([]byte("/rename Alice\n"))
+	readUntil(t, bobReader, "That name is registered to someone else.")
+	bob.Write([]byte("/passwd old-password new-password\n"))
+	readUntil(t, bobReader, "You are not logged in to a registered name.")
+
+	// Once alice has left, her name needs her password
+	alice.Close()
+	nextOfKind(t, hub, models.KindLeave)
+
+	mallory, malloryReader, done := connectTestClient(t, hub, "ALICE")
+	defer mallory.Close()
+	for i := 0; i < 3; i++ {
+		if prompt, err := malloryReader.ReadString(':'); err != nil || !strings.Contains(prompt, "[PASSWORD]") {
+			t.Fatalf("Expected a password prompt, got %q (err: %v)", prompt, err)
+		}
+		mallory.Write([]byte("guess " + strconv.Itoa(i) + "\n"))
+		readUntil(t, malloryReader, "Wrong password.")
+	}
+	readUntil(t, malloryReader, "Too many wrong passwords.")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection to be closed after three wrong passwords")
+	}
+
+	returning, returningReader, _ := connectTestClient(t, hub, "alice")
+	defer returning.Close()
+	returningReader.ReadString(':')
+	returning.Write([]byte("wrong password\n"))
+	readUntil(t, returningReader, "Wrong password.")
+	returningReader.ReadString(':')
+	returning.Write([]byte("white-rabbit\n"))
+	returningReader.ReadString('\n') // history
+	if msg := nextOfKind(t, hub, models.KindJoin); msg.Sender != "alice" {
+		t.Errorf("Expected alice to join, got %q", msg.Sender)
+	}
+
+	// While alice is online, nobody is asked for her password and a
+	// different name does not log them in as her
+	impostor, impostorReader, _ := connectTestClient(t, hub, "alice")
+	defer impostor.Close()
+	readUntil(t, impostorReader, "name is already taken")
+	impostorReader.ReadString(':')
+	impostor.Write([]byte("mallory\n"))
+	impostorReader.ReadString('\n') // history
+	nextOfKind(t, hub, models.KindJoin)
+	impostor.Write([]byte("/passwd white-rabbit stolen-rabbit\n"))
+	readUntil(t, impostorReader, "You are not logged in to a registered name.")
+	impostor.Write([]byte("/rename alice\n"))
+	readUntil(t, impostorReader, "That name is registered to someone else.")
+
+	// The owner may change the password and take the name back after a rename
+	returning.Write([]byte("/passwd cheshire-cat looking-glass\n"))
+	readUntil(t, returningReader, "Your current password is wrong.")
+	returning.Write([]byte("/passwd white-rabbit looking-glass\n"))
+	readUntil(t, returningReader, "Your password has been changed.")
+	if err := hub.Accounts.Verify("alice", "looking-glass"); err != nil {
+		t.Errorf("Expected the new password to work, got %v", err)
+	}
+	returning.Write([]byte("/rename rabbit\n"))
+	nextOfKind(t, hub, models.KindRename)
+	returning.Write([]byte("/rename Alice\n"))
+	if msg := nextOfKind(t, hub, models.KindRename); msg.Sender != "Alice" {
+		t.Errorf("Expected the owner to rename back to the name, got %q", msg.Sender)
+	}
+}
@@ -1,6 +1,9 @@
 package tests
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -11,14 +14,15 @@ import (
 
 	br "netcat/broadcast"
 	"netcat/models"
+	"netcat/outbox"
+	"netcat/rooms"
+	"netcat/sessions"
 )
 
 func TestBroadcaster(t *testing.T) {
 	// Setup
-	models.Mu.Lock()
-	models.Clients = make(map[net.Conn]string)
-	models.Broadcast = make(chan string, 10)
-	models.Mu.Unlock()
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
 
 	// Create temporary log file
 	tmpFile, err := ioutil.TempFile("", "test_broadcast_log")
@@ -27,7 +31,7 @@ func TestBroadcaster(t *testing.T) {
 	}
 	defer os.Remove(tmpFile.Name())
 	defer tmpFile.Close()
-	models.LogFile = tmpFile
+	hub.Rooms[models.Lobby].LogFile = tmpFile
 
 	// Create test connections
 	server1, client1 := net.Pipe()
@@ -38,21 +42,23 @@ func TestBroadcaster(t *testing.T) {
 	defer client2.Close()
 
 	// Add clients
-	models.Mu.Lock()
-	models.Clients[server1] = "User1"
-	models.Clients[server2] = "User2"
-	models.Mu.Unlock()
+	hub.Mu.Lock()
+	hub.Clients[server1] = models.NewClient(server1, "User1")
+	hub.Clients[server2] = models.NewClient(server2, "User2")
+	hub.Mu.Unlock()
+	rooms.Join(hub, server1, models.Lobby)
+	rooms.Join(hub, server2, models.Lobby)
 
 	// Start broadcaster in goroutine with done channel
 	broadcasterDone := make(chan bool)
 	go func() {
-		br.Broadcaster()
+		br.Broadcaster(hub)
 		close(broadcasterDone)
 	}()
 
 	// Send test message
 	testMessage := "Test broadcast message\n"
-	models.Broadcast <- testMessage
+	hub.Broadcast <- models.Message{Room: models.Lobby, Body: testMessage}
 
 	// Use WaitGroup to ensure all checks complete
 	var wg sync.WaitGroup
@@ -92,7 +98,7 @@ func TestBroadcaster(t *testing.T) {
 	}
 
 	// Clean up
-	close(models.Broadcast)
+	close(hub.Broadcast)
 
 	// Wait for broadcaster to finish with timeout
 	select {
@@ -105,10 +111,8 @@ func TestBroadcaster(t *testing.T) {
 
 func TestBroadcasterWithFailedConnection(t *testing.T) {
 	// Setup
-	models.Mu.Lock()
-	models.Clients = make(map[net.Conn]string)
-	models.Broadcast = make(chan string, 10)
-	models.Mu.Unlock()
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
 
 	// Create temporary log file
 	tmpFile, err := ioutil.TempFile("", "test_broadcast_log")
@@ -117,7 +121,7 @@ func TestBroadcasterWithFailedConnection(t *testing.T) {
 	}
 	defer os.Remove(tmpFile.Name())
 	defer tmpFile.Close()
-	models.LogFile = tmpFile
+	hub.Rooms[models.Lobby].LogFile = tmpFile
 
 	// Create test connections
 	server1, client1 := net.Pipe()
@@ -126,10 +130,12 @@ func TestBroadcasterWithFailedConnection(t *testing.T) {
 	defer client2.Close()
 
 	// Add clients
-	models.Mu.Lock()
-	models.Clients[server1] = "User1"
-	models.Clients[server2] = "User2"
-	models.Mu.Unlock()
+	hub.Mu.Lock()
+	hub.Clients[server1] = models.NewClient(server1, "User1")
+	hub.Clients[server2] = models.NewClient(server2, "User2")
+	hub.Mu.Unlock()
+	rooms.Join(hub, server1, models.Lobby)
+	rooms.Join(hub, server2, models.Lobby)
 
 	// Close one server connection to simulate failure
 	server1.Close()
@@ -137,13 +143,13 @@ func TestBroadcasterWithFailedConnection(t *testing.T) {
 	// Start broadcaster with done channel
 	broadcasterDone := make(chan bool)
 	go func() {
-		br.Broadcaster()
+		br.Broadcaster(hub)
 		close(broadcasterDone)
 	}()
 
 	// Send test message
 	testMessage := "Test message\n"
-	models.Broadcast <- testMessage
+	hub.Broadcast <- models.Message{Room: models.Lobby, Body: testMessage}
 
 	// Use WaitGroup for client check
 	var wg sync.WaitGroup
@@ -165,19 +171,20 @@ func TestBroadcasterWithFailedConnection(t *testing.T) {
 	// Wait for client check to complete
 	wg.Wait()
 
-	// Check that failed connection was removed from clients
-	models.Mu.Lock()
-	if _, exists := models.Clients[server1]; exists {
-		t.Error("Failed connection should have been removed from clients map")
+	// The failed connection is left for its handler to clean up, so its
+	// room is still known when the departure is announced
+	hub.Mu.Lock()
+	if _, exists := hub.Clients[server1]; !exists || hub.ClientRoom[server1] != models.Lobby {
+		t.Error("Broadcaster should leave the failed connection to its handler")
 	}
-	if _, exists := models.Clients[server2]; !exists {
+	if _, exists := hub.Clients[server2]; !exists {
 		t.Error("Working connection should still exist in clients map")
 	}
-	models.Mu.Unlock()
+	hub.Mu.Unlock()
 
 	// Clean up
 	server2.Close()
-	close(models.Broadcast)
+	close(hub.Broadcast)
 
 	// Wait for broadcaster to finish with timeout
 	select {
@@ -187,3 +194,218 @@ func TestBroadcasterWithFailedConnection(t *testing.T) {
 		t.Error("Broadcaster did not finish after channel close")
 	}
 }
+
+func TestBroadcasterScopesToRoom(t *testing.T) {
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	lobbyServer, lobbyClient := net.Pipe()
+	roomServer, roomClient := net.Pipe()
+	defer lobbyServer.Close()
+	defer lobbyClient.Close()
+	defer roomServer.Close()
+	defer roomClient.Close()
+
+	rooms.Join(hub, lobbyServer, models.Lobby)
+	rooms.Join(hub, roomServer, "dev")
+
+	go br.Broadcaster(hub)
+	defer close(hub.Broadcast)
+
+	testMessage := "Only for dev\n"
+	hub.Broadcast <- models.Message{Room: "dev", Body: testMessage}
+
+	roomClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 1024)
+	n, err := roomClient.Read(buffer)
+	if err != nil {
+		t.Fatalf("Room member failed to receive message: %v", err)
+	}
+	if string(buffer[:n]) != testMessage {
+		t.Errorf("Expected %q, got %q", testMessage, string(buffer[:n]))
+	}
+
+	lobbyClient.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := lobbyClient.Read(buffer); err == nil {
+		t.Errorf("Lobby member should not receive room messages, got %q", string(buffer[:n]))
+	}
+}
+
+// newOutboxHub returns a hub whose clients all have outboxes: healthy
+// clients are drained in the background and one stuck client never reads
+func newOutboxHub(tb testing.TB, healthy int) (*models.Hub, []net.Conn, func()) {
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 64)
+	hub.Outbox = outbox.Config{Size: 16, Policy: outbox.DropOldest, WriteTimeout: time.Minute}
+
+	var readers []net.Conn
+	var cleanup []func()
+	add := func(name string) net.Conn {
+		server, client := net.Pipe()
+		box := outbox.New(server, hub.Outbox)
+		hub.Clients[server] = models.NewClient(server, name)
+		hub.Outboxes[server] = box
+		rooms.Join(hub, server, models.Lobby)
+		cleanup = append(cleanup, func() {
+			client.Close()
+			box.Close()
+		})
+		return client
+	}
+
+	add("stuck")
+	for i := 0; i < healthy; i++ {
+		readers = append(readers, add(fmt.Sprintf("reader%d", i)))
+	}
+
+	return hub, readers, func() {
+		for _, fn := range cleanup {
+			fn()
+		}
+	}
+}
+
+func TestBroadcasterStuckPeerDoesNotStall(t *testing.T) {
+	hub, readers, cleanup := newOutboxHub(t, 3)
+	defer cleanup()
+
+	go br.Broadcaster(hub)
+	defer close(hub.Broadcast)
+
+	// Far more messages than the stuck client's outbox can hold
+	const count = 100
+	var wg sync.WaitGroup
+	for _, client := range readers {
+		wg.Add(1)
+		go func(client net.Conn) {
+			defer wg.Done()
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(client)
+			last := fmt.Sprintf("message %d\n", count-1)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Errorf("Healthy client never received the last message: %v", err)
+					return
+				}
+				if line == last {
+					return
+				}
+			}
+		}(client)
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case hub.Broadcast <- models.Message{Room: models.Lobby, Body: fmt.Sprintf("message %d\n", i)}:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Broadcaster blocked on message %d", i)
+		}
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkBroadcastWithStuckPeer(b *testing.B) {
+	hub, readers, cleanup := newOutboxHub(b, 8)
+	defer cleanup()
+
+	for _, client := range readers {
+		go io.Copy(io.Discard, client)
+	}
+
+	go br.Broadcaster(hub)
+	defer close(hub.Broadcast)
+
+	msg := models.Message{Room: models.Lobby, Body: "benchmark message\n"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hub.Broadcast <- msg
+	}
+}
+
+func TestBroadcasterStampsPerRecipient(t *testing.T) {
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	var log strings.Builder
+	hub.Rooms[models.Lobby].LogFile = &log
+
+	utcServer, utcClient := net.Pipe()
+	eastServer, eastClient := net.Pipe()
+	defer utcServer.Close()
+	defer utcClient.Close()
+	defer eastServer.Close()
+	defer eastClient.Close()
+
+	rooms.Join(hub, utcServer, models.Lobby)
+	rooms.Join(hub, eastServer, models.Lobby)
+	hub.Mu.Lock()
+	hub.Prefs[utcServer] = models.Prefs{Location: time.UTC}
+	hub.Prefs[eastServer] = models.Prefs{TimeFormat: "15:04", Location: time.FixedZone("UTC+9", 9*60*60)}
+	hub.Mu.Unlock()
+
+	go br.Broadcaster(hub)
+
+	sent := time.Date(2025, 3, 1, 22, 30, 0, 0, time.UTC)
+	hub.Broadcast <- models.Message{Kind: models.KindChat, Sender: "alice", Room: models.Lobby, Body: "hi", Time: sent}
+
+	// Members without an outbox are written to in turn, so read from both at once
+	utcLine := make(chan string, 1)
+	eastLine := make(chan string, 1)
+	go func() { line, _ := bufio.NewReader(utcClient).ReadString('\n'); utcLine <- line }()
+	go func() { line, _ := bufio.NewReader(eastClient).ReadString('\n'); eastLine <- line }()
+
+	if line, expected := <-utcLine, "[2025-03-01 22:30:00][alice]: hi\n"; line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+	if line, expected := <-eastLine, "[07:30][alice]: hi\n"; line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+
+	close(hub.Broadcast)
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+	if expected := "[2025-03-01T22:30:00Z][alice]: hi\n"; log.String() != expected {
+		t.Errorf("Expected canonical UTC log line %q, got %q", expected, log.String())
+	}
+}
+
+func TestBroadcasterDropKeepsSession(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.Outbox = outbox.Config{Size: 4, Policy: outbox.Disconnect, WriteTimeout: time.Minute}
+	hub.ResumeGrace = time.Minute
+
+	client, _, done := joinTestClient(t, hub, "alice")
+	defer client.Close()
+
+	go br.Broadcaster(hub)
+	defer close(hub.Broadcast)
+
+	// alice stops reading, so her outbox fills up and the broadcaster
+	// disconnects her
+	for i := 0; i < 10; i++ {
+		hub.Broadcast <- models.NewMessage(models.KindChat, "bob", models.Lobby, fmt.Sprintf("message %d", i))
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleClient did not return after the broadcaster dropped the client")
+	}
+
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+	session, ok := sessions.Find(hub, "alice")
+	if !ok {
+		t.Fatal("Expected a client dropped by the broadcaster to keep its session")
+	}
+	if session.Room != models.Lobby {
+		t.Errorf("Expected the session to remember #%s, got %q", models.Lobby, session.Room)
+	}
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/chatlog"
+	"netcat/models"
+	"netcat/replay"
+	"netcat/server"
+)
+
+func TestJSONLogRoundTrip(t *testing.T) {
+	msg := models.Message{
+		ID:     7,
+		Kind:   models.KindRename,
+		Sender: "bob]: fake",
+		Room:   "dev",
+		Time:   time.Date(2025, 3, 1, 23, 30, 0, 0, time.FixedZone("CET", 3600)),
+		Meta:   map[string]string{"old": "alice"},
+	}
+
+	record := chatlog.Encode(msg)
+	if !strings.HasSuffix(record, "\n") || strings.Count(record, "\n") != 1 {
+		t.Fatalf("Expected a single line record, got %q", record)
+	}
+	if !strings.Contains(record, `"kind":"rename"`) || !strings.Contains(record, `"time":"2025-03-01T22:30:00Z"`) {
+		t.Errorf("Expected kind by name and a UTC time, got %q", record)
+	}
+
+	decoded, err := chatlog.Decode(record)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.ID != msg.ID || decoded.Kind != msg.Kind || decoded.Sender != msg.Sender || !decoded.Time.Equal(msg.Time) || decoded.Meta["old"] != "alice" {
+		t.Errorf("Round trip changed the message: %+v", decoded)
+	}
+
+	if line := chatlog.HistoryLine(record); line != "alice has changed their name to bob]: fake" {
+		t.Errorf("Expected the record as a chat line, got %q", line)
+	}
+	if line := chatlog.HistoryLine("[2025-03-01T22:30:00Z][alice]: hi"); line != "[2025-03-01T22:30:00Z][alice]: hi" {
+		t.Errorf("Text records must pass through, got %q", line)
+	}
+}
+
+func TestServerWritesJSONLog(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	logDir := t.TempDir()
+	newServer := func() *server.Server {
+		return server.New(server.WithLogDir(logDir), server.WithLogFormat(chatlog.JSON), server.WithShutdownGrace(0))
+	}
+
+	srv := newServer()
+	addr := startTestServer(t, srv)
+	conn, reader, _ := dialTestServer(t, addr, ':')
+	conn.Write([]byte("alice\n"))
+	reader.ReadString('\n') // no chat history
+	conn.Write([]byte("hi ]: tricky\n"))
+	reader.ReadString('\n') // echo
+	conn.Write([]byte("/quit\n"))
+	io.Copy(io.Discard, reader) // wait for the session to end
+	conn.Close()
+	srv.Shutdown(context.Background())
+
+	_, port, _ := strings.Cut(addr, ":")
+	path := filepath.Join(logDir, "chat_log_"+port+".log")
+
+	var kinds []string
+	var chat models.Message
+	if _, err := chatlog.Read(path, func(msg models.Message) error {
+		kinds = append(kinds, msg.Kind.String())
+		if msg.Kind == models.KindChat {
+			chat = msg
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if got := strings.Join(kinds, ","); !strings.HasPrefix(got, "join,chat,leave") {
+		t.Errorf("Expected join, chat and leave records, got %s", got)
+	}
+	if chat.Sender != "alice" || chat.Body != "hi ]: tricky" || chat.ID == 0 {
+		t.Errorf("Unexpected chat record: %+v", chat)
+	}
+
+	// History survives a restart on a JSON log, shown as chat lines
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen again on %s: %v", addr, err)
+	}
+	srv = newServer()
+	go srv.Serve(ln)
+	defer srv.Shutdown(context.Background())
+
+	conn, reader, _ = dialTestServer(t, addr, ':')
+	defer conn.Close()
+	conn.Write([]byte("bob\n"))
+	var replayed []string
+	for i := 0; i < 3; i++ {
+		line, _ := reader.ReadString('\n')
+		replayed = append(replayed, line)
+	}
+	if strings.TrimSpace(replayed[0]) != "alice has joined our chat..." || !strings.HasSuffix(replayed[1], "[alice]: hi ]: tricky\n") || replayed[2] != "alice has left our chat.\n" {
+		t.Errorf("Unexpected history after restart: %q", replayed)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.log")
+	at := func(minute int) time.Time { return time.Date(2025, 3, 1, 10, minute, 0, 0, time.UTC) }
+
+	var log strings.Builder
+	log.WriteString("[2025-03-01 09:00:00][old]: written as text\n")
+	for _, msg := range []models.Message{
+		{ID: 1, Kind: models.KindJoin, Sender: "alice", Room: "lobby", Time: at(0)},
+		{ID: 2, Kind: models.KindChat, Sender: "alice", Room: "lobby", Time: at(1), Body: "use *stars* & <tags>"},
+		{ID: 3, Kind: models.KindChat, Sender: "bob", Room: "lobby", Time: at(2), Body: "hello"},
+		{ID: 4, Kind: models.KindRename, Sender: "alicia", Room: "lobby", Time: at(3), Meta: map[string]string{"old": "alice"}},
+		{ID: 5, Kind: models.KindChat, Sender: "alicia", Room: "lobby", Time: at(4), Body: "later"},
+	} {
+		log.WriteString(chatlog.Encode(msg))
+	}
+	os.WriteFile(path, []byte(log.String()), 0644)
+
+	run := func(args ...string) (string, string) {
+		var stdout, stderr bytes.Buffer
+		if err := replay.Run(append(args, path), &stdout, &stderr); err != nil {
+			t.Fatalf("replay %v failed: %v", args, err)
+		}
+		return stdout.String(), stderr.String()
+	}
+
+	text, warnings := run("-tz", "UTC")
+	expected := "alice has joined our chat...\n" +
+		"[2025-03-01 10:01:00][alice]: use *stars* & <tags>\n" +
+		"[2025-03-01 10:02:00][bob]: hello\n" +
+		"alice has changed their name to alicia\n" +
+		"[2025-03-01 10:04:00][alicia]: later\n"
+	if text != expected {
+		t.Errorf("Expected text replay %q, got %q", expected, text)
+	}
+	if !strings.Contains(warnings, "Skipped 1 lines") {
+		t.Errorf("Expected a warning about the text line, got %q", warnings)
+	}
+
+	byUser, _ := run("-tz", "UTC", "-user", "ALICE")
+	if strings.Contains(byUser, "bob") || strings.Contains(byUser, "later") || !strings.Contains(byUser, "changed their name") {
+		t.Errorf("Expected only alice's messages and her rename, got %q", byUser)
+	}
+
+	window, _ := run("-tz", "UTC", "-since", "2025-03-01 10:01:00", "-until", "2025-03-01 10:03:00")
+	if window != "[2025-03-01 10:01:00][alice]: use *stars* & <tags>\n[2025-03-01 10:02:00][bob]: hello\n" {
+		t.Errorf("Unexpected time window replay: %q", window)
+	}
+
+	markdown, _ := run("-tz", "UTC", "-format", "markdown", "-user", "alice", "-until", "2025-03-01 10:02:00")
+	if !strings.Contains(markdown, "- `2025-03-01 10:01:00` **alice**: use \\*stars\\* & \\<tags\\>\n") || !strings.Contains(markdown, "- _alice has joined our chat..._\n") {
+		t.Errorf("Unexpected markdown replay: %q", markdown)
+	}
+
+	page, _ := run("-tz", "UTC", "-format", "html")
+	if !strings.HasPrefix(page, "<!DOCTYPE html>") || !strings.Contains(page, "<b>alice</b>: use *stars* &amp; &lt;tags&gt;</li>") || !strings.HasSuffix(page, "</html>\n") {
+		t.Errorf("Unexpected HTML replay: %q", page)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := replay.Run([]string{"-format", "pdf", path}, &stdout, &stderr); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
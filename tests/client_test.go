@@ -8,6 +8,7 @@ import (
 	"net"
 	cl "netcat/client"
 	"netcat/models"
+	"netcat/utils"
 	"os"
 	"strings"
 	"testing"
@@ -18,10 +19,8 @@ const testUserName = "TestUser"
 
 func TestHandleClientBasicFlow(t *testing.T) {
 	// Setup
-	models.Mu.Lock()
-	models.Clients = make(map[net.Conn]string)
-	models.Broadcast = make(chan string, 10)
-	models.Mu.Unlock()
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
 
 	// Create test logo file
 	logoFile, err := ioutil.TempFile("", "logo.txt")
@@ -56,10 +55,14 @@ func TestHandleClientBasicFlow(t *testing.T) {
 	defer server.Close()
 	defer client.Close()
 
+	if err := hub.Rooms[models.Lobby].History.Load(historyFile.Name()); err != nil {
+		t.Fatalf("Failed to load history: %v", err)
+	}
+
 	// Start client handler in goroutine
 	done := make(chan bool)
 	go func() {
-		cl.HandleClient(server, historyFile.Name())
+		cl.HandleClient(hub, server)
 		done <- true
 	}()
 
@@ -138,21 +141,17 @@ func TestHandleClientBasicFlow(t *testing.T) {
 	}
 
 	// Check if message was broadcasted with timeout
-	select {
-	case broadcastMsg := <-models.Broadcast:
-		if !strings.Contains(broadcastMsg, testUserName) || !strings.Contains(broadcastMsg, strings.TrimSpace(clientMessage)) {
-			t.Errorf("Expected broadcast message to contain user %q and message %q, got: %q", testUserName, strings.TrimSpace(clientMessage), broadcastMsg)
-		}
-	case <-time.After(2 * time.Second):
-		t.Error("Timeout waiting for message to be broadcasted")
+	msg := nextOfKind(t, hub, models.KindChat)
+	if msg.Sender != testUserName || msg.Body != strings.TrimSpace(clientMessage) {
+		t.Errorf("Expected broadcast message from %q with body %q, got: %+v", testUserName, strings.TrimSpace(clientMessage), msg)
 	}
 
 	// Verify client was added to map
-	models.Mu.Lock()
-	if name, exists := models.Clients[server]; !exists || name != testUserName {
+	hub.Mu.Lock()
+	if name, exists := hub.Name(server), hub.Clients[server] != nil; !exists || name != testUserName {
 		t.Errorf("Expected client to be in map with name %q, got: name=%q, exists=%v", testUserName, name, exists)
 	}
-	models.Mu.Unlock()
+	hub.Mu.Unlock()
 
 	// Send quit command to clean up
 	_, err = client.Write([]byte("/quit\n"))
@@ -178,10 +177,12 @@ func TestHandleClientEmptyName(t *testing.T) {
 	}
 	defer os.Remove("logo.txt")
 
+	hub := models.NewHub()
+
 	server, client := net.Pipe()
 	defer client.Close()
 
-	go cl.HandleClient(server, "nonexistent.txt")
+	go cl.HandleClient(hub, server)
 
 	reader := bufio.NewReader(client)
 
@@ -198,21 +199,79 @@ func TestHandleClientEmptyName(t *testing.T) {
 		t.Errorf("Expected invalid name message, got: %s", response)
 	}
 
-	// Connection should be closed by server
-	// We can test this by trying to write and expecting an error
-	time.Sleep(100 * time.Millisecond)
-	_, err = client.Write([]byte("test"))
-	if err == nil {
-		t.Error("Expected connection to be closed after invalid name")
+	// The server asks again instead of disconnecting
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	prompt, err := reader.ReadString(':')
+	if err != nil || !strings.Contains(prompt, "[ENTER YOUR NAME]:") {
+		t.Fatalf("Expected the name prompt again, got %q (err: %v)", prompt, err)
+	}
+
+	client.Write([]byte("SecondTry\n"))
+	reader.ReadString('\n') // no chat history
+
+	hub.Mu.Lock()
+	if name := hub.Name(server); name != "SecondTry" {
+		t.Errorf("Expected client to be registered as SecondTry, got %q", name)
+	}
+	hub.Mu.Unlock()
+	client.Close()
+}
+
+func TestHandleClientDuplicateName(t *testing.T) {
+	err := ioutil.WriteFile("logo.txt", []byte("Welcome!"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	taken, takenClient := net.Pipe()
+	defer taken.Close()
+	defer takenClient.Close()
+	hub.Clients[taken] = models.NewClient(taken, "Alice")
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go cl.HandleClient(hub, server)
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+
+	for _, attempt := range []string{"alice", "Admin", "bad name"} {
+		client.Write([]byte(attempt + "\n"))
+		response, _ := reader.ReadString('\n')
+		if !strings.Contains(response, "Invalid name") {
+			t.Errorf("Expected %q to be refused, got %q", attempt, response)
+		}
+		if prompt, _ := reader.ReadString(':'); !strings.Contains(prompt, "[ENTER YOUR NAME]:") {
+			t.Errorf("Expected the name prompt again after %q, got %q", attempt, prompt)
+		}
 	}
+
+	client.Write([]byte("bob\n"))
+	reader.ReadString('\n') // no chat history
+
+	client.Write([]byte("/rename ALICE\n"))
+	if response, _ := reader.ReadString('\n'); !strings.Contains(response, "already taken") {
+		t.Errorf("Expected rename to a taken name to be refused, got %q", response)
+	}
+
+	hub.Mu.Lock()
+	if name := hub.Name(server); name != "bob" {
+		t.Errorf("Expected name to stay bob, got %q", name)
+	}
+	hub.Mu.Unlock()
 }
 
 func TestHandleClientRename(t *testing.T) {
 	// Setup
-	models.Mu.Lock()
-	models.Clients = make(map[net.Conn]string)
-	models.Broadcast = make(chan string, 10)
-	models.Mu.Unlock()
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
 
 	// Create logo file
 	logoContent := "Welcome!"
@@ -226,7 +285,7 @@ func TestHandleClientRename(t *testing.T) {
 	defer server.Close()
 	defer client.Close()
 
-	go cl.HandleClient(server, "nonexistent.txt")
+	go cl.HandleClient(hub, server)
 
 	reader := bufio.NewReader(client)
 
@@ -247,11 +306,11 @@ func TestHandleClientRename(t *testing.T) {
 
 	// Check if name was updated in clients map
 	time.Sleep(100 * time.Millisecond)
-	models.Mu.Lock()
-	if name, exists := models.Clients[server]; !exists || name != "NewName" {
+	hub.Mu.Lock()
+	if name, exists := hub.Name(server), hub.Clients[server] != nil; !exists || name != "NewName" {
 		t.Errorf("Expected client name to be updated to 'NewName', got: %s, exists: %v", name, exists)
 	}
-	models.Mu.Unlock()
+	hub.Mu.Unlock()
 
 	// Test invalid rename
 	client.Write([]byte("/rename \n"))
@@ -265,20 +324,18 @@ func TestHandleClientRename(t *testing.T) {
 	} else if err != nil {
 		// This might be because the message went to broadcast instead
 		// Let's check the map to make sure name wasn't changed
-		models.Mu.Lock()
-		if name := models.Clients[server]; name != "NewName" {
+		hub.Mu.Lock()
+		if name := hub.Name(server); name != "NewName" {
 			t.Errorf("Client name should still be 'NewName' after invalid rename, got: %s", name)
 		}
-		models.Mu.Unlock()
+		hub.Mu.Unlock()
 	}
 }
 
 func TestHandleClientQuit(t *testing.T) {
 	// Setup
-	models.Mu.Lock()
-	models.Clients = make(map[net.Conn]string)
-	models.Broadcast = make(chan string, 10)
-	models.Mu.Unlock()
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
 
 	// Create logo file
 	logoContent := "Welcome!"
@@ -294,7 +351,7 @@ func TestHandleClientQuit(t *testing.T) {
 	// Channel to know when HandleClient finishes
 	done := make(chan bool)
 	go func() {
-		cl.HandleClient(server, "nonexistent.txt")
+		cl.HandleClient(hub, server)
 		done <- true
 	}()
 
@@ -332,11 +389,11 @@ func TestHandleClientQuit(t *testing.T) {
 	client.SetReadDeadline(time.Time{})
 
 	// Verify client is in map
-	models.Mu.Lock()
-	if _, exists := models.Clients[server]; !exists {
+	hub.Mu.Lock()
+	if _, exists := hub.Clients[server]; !exists {
 		t.Error("Client should be in map before quit")
 	}
-	models.Mu.Unlock()
+	hub.Mu.Unlock()
 
 	// Send quit command
 	_, err = client.Write([]byte("/quit\n"))
@@ -353,9 +410,68 @@ func TestHandleClientQuit(t *testing.T) {
 	}
 
 	// Verify client was removed from map
-	models.Mu.Lock()
-	if _, exists := models.Clients[server]; exists {
+	hub.Mu.Lock()
+	if _, exists := hub.Clients[server]; exists {
 		t.Error("Client should be removed from map after quit")
 	}
-	models.Mu.Unlock()
+	hub.Mu.Unlock()
+}
+
+func TestHandleClientReply(t *testing.T) {
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	err := ioutil.WriteFile("logo.txt", []byte("Welcome!"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go cl.HandleClient(hub, server)
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+	client.Write([]byte("replier\n"))
+	reader.ReadString('\n') // no chat history
+
+	client.Write([]byte("/reply anyone there?\n"))
+	if line, _ := reader.ReadString('\n'); !strings.Contains(line, "Nobody has sent you a direct message") {
+		t.Errorf("Expected no reply target message, got %q", line)
+	}
+
+	// Another user DMs us, then we reply without naming them
+	otherServer, otherClient := net.Pipe()
+	defer otherServer.Close()
+	defer otherClient.Close()
+	hub.Mu.Lock()
+	hub.Clients[otherServer] = models.NewClient(otherServer, "sender")
+	hub.Mu.Unlock()
+
+	otherReader := bufio.NewReader(otherClient)
+	otherClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	go utils.SendDirect(hub, otherServer, "replier", "hi there")
+	if line, _ := reader.ReadString('\n'); !strings.Contains(line, "[DM from sender]: hi there") {
+		t.Errorf("Expected DM from sender, got %q", line)
+	}
+	otherReader.ReadString('\n') // echo of the DM
+
+	client.Write([]byte("/reply hello back\n"))
+	if line, _ := otherReader.ReadString('\n'); !strings.Contains(line, "[DM from replier]: hello back") {
+		t.Errorf("Expected reply to reach sender, got %q", line)
+	}
+	if line, _ := reader.ReadString('\n'); !strings.Contains(line, "[DM to sender]: hello back") {
+		t.Errorf("Expected reply echo, got %q", line)
+	}
+
+	for len(hub.Broadcast) > 0 {
+		if msg := <-hub.Broadcast; msg.Kind != models.KindJoin {
+			t.Errorf("DMs must not be broadcast, got %+v", msg)
+		}
+	}
 }
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/models"
+	"netcat/server"
+)
+
+func TestCommandDispatch(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	client, reader, done := joinTestClient(t, hub, "alice")
+	defer client.Close()
+
+	// Unknown commands are reported to the sender, not sent as chat
+	client.Write([]byte("/shrug\n"))
+	readUntil(t, reader, "Unknown command /shrug. Type /help to see the commands.")
+	client.Write([]byte("//shrug\n"))
+	if msg := nextOfKind(t, hub, models.KindChat); msg.Body != "/shrug" {
+		t.Errorf("Expected // to send a chat line starting with /, got %q", msg.Body)
+	}
+
+	// Arguments are checked against the command's usage
+	client.Write([]byte("/msg bob\n"))
+	readUntil(t, reader, "Usage: /msg <name> <message>")
+	client.Write([]byte("/register two words\n"))
+	readUntil(t, reader, "Usage: /register <password>")
+
+	// Aliases run the same command
+	client.Write([]byte("/M bob hello\n"))
+	readUntil(t, reader, "No user named bob is connected.")
+
+	// Operator commands need the role
+	client.Write([]byte("/kick bob\n"))
+	readUntil(t, reader, "You are not an operator.")
+
+	client.Write([]byte("/exit\n"))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected /exit to end the session")
+	}
+	select {
+	case msg := <-hub.Broadcast:
+		if msg.Kind == models.KindChat {
+			t.Errorf("Expected no command to be sent as chat, got %q", msg.Body)
+		}
+	default:
+	}
+}
+
+func TestHelp(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.Features.DirectMessages = false
+
+	client, reader, _ := joinTestClient(t, hub, "alice")
+	defer client.Close()
+
+	readHelp := func() string {
+		t.Helper()
+		var b strings.Builder
+		for {
+			line := readUntil(t, reader, "\n")
+			b.WriteString(line)
+			if strings.HasPrefix(line, "Type /help <command>") {
+				return b.String()
+			}
+		}
+	}
+
+	client.Write([]byte("/help\n"))
+	list := readHelp()
+	for _, expected := range []string{"/join <room>", "/history [n] [before <time>]", "/quit", "Switch to a room"} {
+		if !strings.Contains(list, expected) {
+			t.Errorf("Expected /help to list %q, got:\n%s", expected, list)
+		}
+	}
+	// Disabled and operator commands are left out
+	for _, unexpected := range []string{"/msg", "/kick"} {
+		if strings.Contains(list, unexpected) {
+			t.Errorf("Expected /help not to list %q, got:\n%s", unexpected, list)
+		}
+	}
+
+	client.Write([]byte("/help /rename\n"))
+	if line := readUntil(t, reader, "\n"); line != "Usage: /rename <new_name>\n" {
+		t.Errorf("Expected the usage of /rename, got %q", line)
+	}
+	if line := readUntil(t, reader, "\n"); line != "Aliases: /nick\n" {
+		t.Errorf("Expected the aliases of /rename, got %q", line)
+	}
+	readUntil(t, reader, "Change your name.")
+
+	client.Write([]byte("/help msg\n"))
+	readUntil(t, reader, "Unknown command /msg.")
+
+	// Operators see their commands too
+	hub.Mu.Lock()
+	for conn := range hub.Clients {
+		hub.Operators[conn] = struct{}{}
+	}
+	hub.Mu.Unlock()
+	client.Write([]byte("/help\n"))
+	if list := readHelp(); !strings.Contains(list, "/ban <name|ip> [duration] [reason]") {
+		t.Errorf("Expected operators to see /ban, got:\n%s", list)
+	}
+}
+
+func TestRenameIsAnEvent(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	var log strings.Builder
+	srv := server.New(server.WithLogWriter(&log), server.WithShutdownGrace(0), server.WithRenameCooldown(time.Hour))
+	addr := startTestServer(t, srv)
+
+	bob, bobReader, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	defer alice.Close()
+	alice.Write([]byte("alice\n"))
+	readUntil(t, bobReader, "alice has joined")
+
+	// The rename is announced once and never echoed as chat
+	alice.Write([]byte("/rename alicia\n"))
+	readUntil(t, bobReader, "alice has changed their name to alicia")
+	readUntil(t, aliceReader, "You are now known as alicia.")
+	alice.Write([]byte("/rename ally\n"))
+	readUntil(t, aliceReader, "You can change your name again in 1h")
+	alice.Write([]byte("still here\n"))
+	if line := readUntil(t, bobReader, "still here"); !strings.Contains(line, "[alicia]: still here") {
+		t.Errorf("Expected chat from the new name, got %q", line)
+	}
+
+	bob.Write([]byte("/whowas alice\n"))
+	readUntil(t, bobReader, "Name changes of alice:")
+	readUntil(t, bobReader, "alice changed their name to alicia")
+	readUntil(t, bobReader, "alice is now known as alicia, who is online.")
+
+	// The departure uses the current name
+	alice.Write([]byte("/quit\n"))
+	readUntil(t, bobReader, "alicia has left our chat.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if !strings.Contains(log.String(), "alice has changed their name to alicia") {
+		t.Errorf("Expected the rename in the log, got:\n%s", log.String())
+	}
+	if strings.Contains(log.String(), "/rename") {
+		t.Errorf("Expected no /rename chat line in the log, got:\n%s", log.String())
+	}
+}
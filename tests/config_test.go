@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/certs"
+	"netcat/config"
+	"netcat/server"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "chat.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestParseDefaults(t *testing.T) {
+	banner := writeConfigFile(t, "hello")
+
+	cfg, err := config.Parse([]string{"-banner", banner}, io.Discard)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if cfg.Addr() != ":9060" {
+		t.Errorf("Expected default address :9060, got %q", cfg.Addr())
+	}
+	if cfg.MaxClients != 10 {
+		t.Errorf("Expected default max clients 10, got %d", cfg.MaxClients)
+	}
+
+	// The default banner is optional
+	if _, err := os.Stat(config.DefaultBanner); err == nil {
+		t.Fatalf("Expected no %s in the test directory", config.DefaultBanner)
+	}
+	if _, err := config.Parse(nil, io.Discard); err != nil {
+		t.Errorf("Expected the defaults to be valid without %s, got %v", config.DefaultBanner, err)
+	}
+}
+
+func TestParsePositionalPort(t *testing.T) {
+	banner := writeConfigFile(t, "hello")
+
+	cfg, err := config.Parse([]string{"-banner", banner, "2525"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if cfg.Port != 2525 {
+		t.Errorf("Expected port 2525, got %d", cfg.Port)
+	}
+
+	if _, err := config.Parse([]string{"2525", "extra"}, io.Discard); err == nil || !strings.Contains(err.Error(), "[USAGE]") {
+		t.Errorf("Expected usage error for two positional arguments, got %v", err)
+	}
+}
+
+func TestParseConfigFileAndFlags(t *testing.T) {
+	banner := writeConfigFile(t, "hello")
+	path := writeConfigFile(t, `{
+		"address": "127.0.0.1",
+		"port": 4000,
+		"banner_file": "`+banner+`",
+		"max_clients": 3,
+		"history_lines": 20,
+		"shutdown_grace": "1s",
+		"overflow_policy": "disconnect",
+		"features": {"rooms": false, "direct_messages": true, "history": true}
+	}`)
+
+	cfg, err := config.Parse([]string{"-config", path, "-max-clients", "7"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if cfg.Addr() != "127.0.0.1:4000" {
+		t.Errorf("Expected address from file, got %q", cfg.Addr())
+	}
+	if cfg.MaxClients != 7 {
+		t.Errorf("Expected flag to override file, got max clients %d", cfg.MaxClients)
+	}
+	if cfg.HistoryLines != 20 {
+		t.Errorf("Expected history lines 20, got %d", cfg.HistoryLines)
+	}
+	if time.Duration(cfg.ShutdownGrace) != time.Second {
+		t.Errorf("Expected shutdown grace 1s, got %v", time.Duration(cfg.ShutdownGrace))
+	}
+	if cfg.Features.Rooms {
+		t.Error("Expected rooms to be disabled by the config file")
+	}
+	if len(cfg.Options()) == 0 {
+		t.Error("Expected server options from the config")
+	}
+}
+
+func TestParseRejectsInvalidConfig(t *testing.T) {
+	unknown := writeConfigFile(t, `{"prot": 4000}`)
+	if _, err := config.Parse([]string{"-config", unknown}, io.Discard); err == nil {
+		t.Error("Expected unknown config fields to be rejected")
+	}
+
+	badDuration := writeConfigFile(t, `{"write_timeout": "soon"}`)
+	if _, err := config.Parse([]string{"-config", badDuration}, io.Discard); err == nil {
+		t.Error("Expected an invalid duration to be rejected")
+	}
+
+	_, err := config.Parse([]string{"-port", "70000", "-max-clients", "0", "-overflow", "explode", "-banner", "missing.txt", "-log-format", "xml", "-flood-burst", "-1", "-oversize", "chop", "-tls-port", "9443", "-resume-grace", "-1s", "-auto-away", "-1s"}, io.Discard)
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, expected := range []string{"port", "max_clients", "overflow_policy", "banner_file", "log_format", "flood", "oversize_policy", "tls_port", "resume_grace", "auto_away"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected the error to mention %s, got: %v", expected, err)
+		}
+	}
+}
+
+func TestParseNameRulesAndShutdownNotice(t *testing.T) {
+	banner := writeConfigFile(t, "hello")
+	path := writeConfigFile(t, `{
+		"banner_file": "`+banner+`",
+		"shutdown_notice": "Back in %d seconds",
+		"name_rules": {"min_length": 3, "max_length": 12, "allowed_punctuation": "-", "reserved": ["root"]}
+	}`)
+
+	cfg, err := config.Parse([]string{"-config", path, "-name-max", "8"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	rules := server.New(cfg.Options()...).Hub().NameRules
+	if rules.MinLength != 3 || rules.MaxLength != 8 || rules.AllowedPunctuation != "-" {
+		t.Errorf("Expected the file's rules with the flag's max length, got %+v", rules)
+	}
+	if len(rules.Reserved) != 1 || rules.Reserved[0] != "root" {
+		t.Errorf("Expected root to be reserved, got %v", rules.Reserved)
+	}
+	if cfg.ShutdownNotice != "Back in %d seconds" {
+		t.Errorf("Expected the shutdown notice from the file, got %q", cfg.ShutdownNotice)
+	}
+
+	_, err = config.Parse([]string{"-banner", banner, "-name-min", "0", "-shutdown-notice", "Bye %s"}, io.Discard)
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, expected := range []string{"min_length", "shutdown_notice"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected the error to mention %s, got: %v", expected, err)
+		}
+	}
+	_, err = config.Parse([]string{"-banner", banner, "-name-min", "5", "-name-max", "4"}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "max_length") {
+		t.Errorf("Expected a max length below the min length to be rejected, got %v", err)
+	}
+}
+
+func TestParseTLS(t *testing.T) {
+	banner := writeConfigFile(t, "hello")
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := certs.Run([]string{"-cert", certFile, "-key", keyFile}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("gencert failed: %v", err)
+	}
+
+	cfg, err := config.Parse([]string{"-banner", banner, "-tls-port", "9443", "-tls-cert", certFile, "-tls-key", keyFile, "-plain=false"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if cfg.TLSAddr() != ":9443" || cfg.Plain {
+		t.Errorf("Expected only a TLS listener on :9443, got %q (plain %v)", cfg.TLSAddr(), cfg.Plain)
+	}
+	if tlsConfig, err := cfg.TLSConfig(); err != nil || len(tlsConfig.Certificates) != 1 {
+		t.Errorf("Expected the certificate to load, got %v", err)
+	}
+
+	if _, err := config.Parse([]string{"-banner", banner, "-plain=false"}, io.Discard); err == nil {
+		t.Error("Expected a server with no listeners to be rejected")
+	}
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/flood"
+	"netcat/models"
+)
+
+func TestFloodGuardEscalates(t *testing.T) {
+	limiter := flood.New(flood.Config{Burst: 2, Refill: time.Second, Throttle: time.Second, Mute: time.Minute, Forgive: time.Minute})
+	guard := limiter.Guard("10.0.0.1")
+	defer guard.Close()
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if v := guard.Check(now, ""); v.Action != flood.Allow {
+			t.Fatalf("Expected the burst to be allowed, got %v", v.Action)
+		}
+	}
+
+	expected := []flood.Action{flood.Warn, flood.Throttle, flood.Drop}
+	for _, action := range expected {
+		if v := guard.Check(now, ""); v.Action != action {
+			t.Errorf("Expected %v, got %v", action, v.Action)
+		}
+	}
+
+	// The throttle is over, but one token has been earned since
+	now = now.Add(1500 * time.Millisecond)
+	if v := guard.Check(now, ""); v.Action != flood.Allow {
+		t.Errorf("Expected a refilled token to be allowed, got %v", v.Action)
+	}
+	if v := guard.Check(now, ""); v.Action != flood.Mute || v.Reason != flood.TooFast || v.For != time.Minute {
+		t.Errorf("Expected a mute for a minute, got %+v", v)
+	}
+	if v := guard.Check(now, ""); v.Action != flood.Disconnect {
+		t.Errorf("Expected a disconnect, got %v", v.Action)
+	}
+
+	// Keeping to the limits for long enough clears the record
+	now = now.Add(2 * time.Minute)
+	guard.Check(now, "")
+	guard.Check(now, "")
+	if v := guard.Check(now, ""); v.Action != flood.Warn {
+		t.Errorf("Expected violations to be forgiven, got %v", v.Action)
+	}
+}
+
+func TestFloodGuardRepeats(t *testing.T) {
+	limiter := flood.New(flood.Config{Repeats: 2, RepeatWindow: time.Minute})
+	guard := limiter.Guard("10.0.0.1")
+	defer guard.Close()
+
+	now := time.Now()
+	guard.Check(now, "buy now")
+	if v := guard.Check(now, "BUY NOW"); v.Action != flood.Allow {
+		t.Errorf("Expected the second copy to be allowed, got %v", v.Action)
+	}
+	if v := guard.Check(now, "buy now"); v.Action != flood.Warn || v.Reason != flood.Repeated {
+		t.Errorf("Expected a warning for the third copy, got %+v", v)
+	}
+
+	// Commands are not compared, and an older copy no longer counts
+	if v := guard.Check(now, ""); v.Action != flood.Allow {
+		t.Errorf("Expected a command to be allowed, got %v", v.Action)
+	}
+	if v := guard.Check(now.Add(2*time.Minute), "buy now"); v.Action != flood.Allow {
+		t.Errorf("Expected a copy outside the window to be allowed, got %v", v.Action)
+	}
+}
+
+func TestFloodHostLimitIsShared(t *testing.T) {
+	limiter := flood.New(flood.Config{HostBurst: 3, HostRefill: time.Hour})
+	first := limiter.Guard("10.0.0.1")
+	second := limiter.Guard("10.0.0.1")
+	other := limiter.Guard("10.0.0.2")
+
+	now := time.Now()
+	first.Check(now, "")
+	first.Check(now, "")
+	if v := second.Check(now, ""); v.Action != flood.Allow {
+		t.Errorf("Expected the last shared token to be allowed, got %v", v.Action)
+	}
+	if v := second.Check(now, ""); v.Action != flood.Warn {
+		t.Errorf("Expected the address to be out of tokens, got %v", v.Action)
+	}
+	if v := other.Check(now, ""); v.Action != flood.Allow {
+		t.Errorf("Expected another address to have its own tokens, got %v", v.Action)
+	}
+
+	// Reconnecting does not reset the address's bucket
+	first.Close()
+	second.Close()
+	again := limiter.Guard("10.0.0.1")
+	defer again.Close()
+	if v := again.Check(now, ""); v.Action != flood.Warn {
+		t.Errorf("Expected the bucket to outlive the connections, got %v", v.Action)
+	}
+}
+
+func TestHandleClientFloodEscalation(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.Flood = flood.New(flood.Config{Burst: 2, Refill: time.Hour, Throttle: 50 * time.Millisecond, Mute: time.Minute})
+
+	client, reader, done := joinTestClient(t, hub, "flooder")
+	defer client.Close()
+
+	client.Write([]byte("one\n"))
+	client.Write([]byte("two\n"))
+	if msg := nextOfKind(t, hub, models.KindChat); msg.Body != "one" {
+		t.Errorf("Expected the first message through, got %q", msg.Body)
+	}
+	nextOfKind(t, hub, models.KindChat)
+
+	client.Write([]byte("three\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, "Please slow down") {
+		t.Errorf("Expected a warning, got %q", line)
+	}
+	client.Write([]byte("four\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, "ignored for 50ms") {
+		t.Errorf("Expected to be throttled, got %q", line)
+	}
+	client.Write([]byte("five\n"))
+
+	time.Sleep(100 * time.Millisecond)
+	client.Write([]byte("six\n"))
+	mute := nextOfKind(t, hub, models.KindMute)
+	if mute.Sender != "server" || mute.Meta["target"] != "flooder" || mute.Meta["reason"] != "flooding" {
+		t.Errorf("Expected the server to mute the flooder, got %+v", mute)
+	}
+
+	client.Write([]byte("seven\n"))
+	if line := readUntil(t, reader, "disconnected"); !strings.Contains(line, "flooding") {
+		t.Errorf("Expected to be disconnected for flooding, got %q", line)
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+	<-done
+	nextOfKind(t, hub, models.KindLeave)
+}
+
+func TestHandleClientDuplicateSuppression(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.Flood = flood.New(flood.Config{Repeats: 1, RepeatWindow: time.Minute, Mute: time.Minute})
+
+	client, reader, _ := joinTestClient(t, hub, "parrot")
+	defer client.Close()
+
+	client.Write([]byte("hello\n"))
+	nextOfKind(t, hub, models.KindChat)
+
+	client.Write([]byte("hello\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, "already sent that message") {
+		t.Errorf("Expected the copy to be refused, got %q", line)
+	}
+
+	client.Write([]byte("something else\n"))
+	if msg := nextOfKind(t, hub, models.KindChat); msg.Body != "something else" {
+		t.Errorf("Expected a new message through, got %q", msg.Body)
+	}
+}
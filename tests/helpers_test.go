@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	cl "netcat/client"
+	"netcat/models"
+	"netcat/server"
+)
+
+// startTestServer serves srv on a random local port and returns its address
+func startTestServer(t *testing.T, srv *server.Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve(ln)
+	return ln.Addr().String()
+}
+
+// dialTestServer connects to addr and reads up to the first occurrence of delim
+func dialTestServer(t *testing.T, addr string, delim byte) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	text, err := reader.ReadString(delim)
+	if err != nil {
+		t.Fatalf("Failed to read from server: %v (got %q)", err, text)
+	}
+	return conn, reader, text
+}
+
+// connectTestClient starts a session over a pipe and answers the name prompt
+func connectTestClient(t *testing.T, hub *models.Hub, name string) (net.Conn, *bufio.Reader, chan struct{}) {
+	t.Helper()
+	conn, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		cl.HandleClient(hub, conn)
+		close(done)
+	}()
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+	client.Write([]byte(name + "\n"))
+	return client, reader, done
+}
+
+// joinTestClient connects a client called name to hub over net.Pipe
+func joinTestClient(t *testing.T, hub *models.Hub, name string) (net.Conn, *bufio.Reader, chan struct{}) {
+	t.Helper()
+	client, reader, done := connectTestClient(t, hub, name)
+	reader.ReadString('\n') // history
+	nextOfKind(t, hub, models.KindJoin)
+	return client, reader, done
+}
+
+// nextOfKind returns the next broadcast message of the given kind, skipping
+// others such as join notices
+func nextOfKind(t *testing.T, hub *models.Hub, kind models.Kind) models.Message {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-hub.Broadcast:
+			if msg.Kind == kind {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for a %s message", kind)
+			return models.Message{}
+		}
+	}
+}
+
+// readUntil reads lines until one contains substr and returns it
+func readUntil(t *testing.T, reader *bufio.Reader, substr string) string {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.Contains(line, substr) {
+			return line
+		}
+		if err != nil {
+			t.Fatalf("Did not receive %q: %v", substr, err)
+		}
+	}
+}
+
+// readExpecting reads lines until one contains substr, failing if a line
+// containing unwanted comes first
+func readExpecting(t *testing.T, reader *bufio.Reader, substr, unwanted string) string {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.Contains(line, unwanted) {
+			t.Fatalf("Got %q before %q", line, substr)
+		}
+		if strings.Contains(line, substr) {
+			return line
+		}
+		if err != nil {
+			t.Fatalf("Did not receive %q: %v", substr, err)
+		}
+	}
+}
+
+// resumeToken reads the resume token a client is given after joining
+func resumeToken(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	line := readUntil(t, reader, "Your resume token is ")
+	token, _, _ := strings.Cut(strings.TrimPrefix(line, "[Your resume token is "), ".")
+	return token
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cl "netcat/client"
+	"netcat/history"
+	"netcat/models"
+)
+
+func TestHistoryBufferKeepsMostRecent(t *testing.T) {
+	buffer := history.NewBuffer(3)
+	for _, line := range []string{"one", "two", "three", "four"} {
+		buffer.Add(line + "\n")
+	}
+
+	if buffer.Len() != 3 {
+		t.Errorf("Expected 3 lines, got %d", buffer.Len())
+	}
+	if got := strings.Join(buffer.Last(0), ","); got != "two,three,four" {
+		t.Errorf("Expected the last three lines, got %q", got)
+	}
+	if got := strings.Join(buffer.Last(2), ","); got != "three,four" {
+		t.Errorf("Expected the last two lines, got %q", got)
+	}
+}
+
+func TestHistoryBufferBefore(t *testing.T) {
+	buffer := history.NewBuffer(10)
+	buffer.Add("[2025-01-01 10:00:00][alice]: first\n")
+	buffer.Add("[2025-01-01 10:05:00][bob]: second\n")
+	buffer.Add("carol has joined our chat...\n")
+	buffer.Add("[2025-01-01 10:10:00][carol]: third\n")
+
+	before, _ := time.ParseInLocation(history.TimeLayout, "2025-01-01 10:10:00", time.Local)
+	got := buffer.Before(before, 2)
+	expected := []string{"[2025-01-01 10:05:00][bob]: second", "carol has joined our chat..."}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	early, _ := time.ParseInLocation(history.TimeLayout, "2025-01-01 09:00:00", time.Local)
+	if got := buffer.Before(early, 5); len(got) != 0 {
+		t.Errorf("Expected nothing before the first line, got %q", got)
+	}
+}
+
+func TestHistoryBufferLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.log")
+	os.WriteFile(path+".1", []byte("old 1\nold 2\n"), 0644)
+	os.WriteFile(path, []byte("new 1\n"), 0644)
+
+	buffer := history.NewBuffer(2)
+	if err := buffer.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := strings.Join(buffer.Last(0), ","); got != "old 2,new 1" {
+		t.Errorf("Expected the most recent lines across segments, got %q", got)
+	}
+}
+
+func TestHandleClientHistoryCommand(t *testing.T) {
+	err := ioutil.WriteFile("logo.txt", []byte("Welcome!"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.HistoryLines = 2
+
+	lobby := hub.Rooms[models.Lobby]
+	for _, line := range []string{
+		"[2025-01-01 10:00:00][alice]: one",
+		"[2025-01-01 10:01:00][alice]: two",
+		"[2025-01-01 10:02:00][alice]: three",
+		"[2025-01-01 10:03:00][alice]: four",
+	} {
+		lobby.History.Add(line)
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go cl.HandleClient(hub, server)
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+	client.Write([]byte("pager\n"))
+
+	readLines := func(n int) []string {
+		var lines []string
+		for i := 0; i < n; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read line: %v", err)
+			}
+			lines = append(lines, strings.TrimPrefix(strings.TrimSpace(line), " "))
+		}
+		return lines
+	}
+
+	// Only the last two lines are replayed on join
+	joined := readLines(2)
+	if !strings.HasSuffix(joined[0], "three") || !strings.HasSuffix(joined[1], "four") {
+		t.Errorf("Expected the last two lines on join, got %q", joined)
+	}
+
+	client.Write([]byte("/history 2 before 2025-01-01 10:02:00\n"))
+	page := readLines(2)
+	if !strings.HasSuffix(page[0], "one") || !strings.HasSuffix(page[1], "two") {
+		t.Errorf("Expected the previous page, got %q", page)
+	}
+
+	client.Write([]byte("/history before 2025-01-01 10:00:00\n"))
+	if line := readLines(1)[0]; line != "[No older history]" {
+		t.Errorf("Expected no older history, got %q", line)
+	}
+
+	client.Write([]byte("/history lots\n"))
+	if line := readLines(1)[0]; !strings.HasPrefix(line, "Usage: /history") {
+		t.Errorf("Expected usage message, got %q", line)
+	}
+}
+
+func TestHistorySplit(t *testing.T) {
+	when, rest, ok := history.Split("[2025-03-01T22:30:00Z][alice]: hi")
+	if !ok || !when.Equal(time.Date(2025, 3, 1, 22, 30, 0, 0, time.UTC)) || rest != "[alice]: hi" {
+		t.Errorf("Failed to split canonical line: %v %q %v", when, rest, ok)
+	}
+
+	// Older logs were stamped in server local time
+	when, _, ok = history.Split("[2025-03-01 22:30:00][alice]: hi")
+	if !ok || !when.Equal(time.Date(2025, 3, 1, 22, 30, 0, 0, time.Local)) {
+		t.Errorf("Failed to split legacy line: %v %v", when, ok)
+	}
+
+	if _, rest, ok := history.Split("alice has joined our chat..."); ok || rest != "alice has joined our chat..." {
+		t.Errorf("Lines without a timestamp must be returned unchanged")
+	}
+
+	prefs := models.Prefs{TimeFormat: "15:04:05", Location: time.UTC}
+	if got := prefs.Render("[2025-03-01T22:30:00Z][alice]: hi"); got != "[22:30:00][alice]: hi" {
+		t.Errorf("Expected restamped line, got %q", got)
+	}
+}
+
+func TestHandleClientSetTimePrefs(t *testing.T) {
+	err := ioutil.WriteFile("logo.txt", []byte("Welcome!"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.Rooms[models.Lobby].History.Add("[2025-03-01T22:30:00Z][alice]: earlier")
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go cl.HandleClient(hub, server)
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+	client.Write([]byte("clock\n"))
+	reader.ReadString('\n') // history
+
+	client.Write([]byte("/set tz UTC\n"))
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "Timestamps now look like") {
+		t.Errorf("Expected confirmation, got %q", line)
+	}
+	client.Write([]byte("/set timefmt time\n"))
+	reader.ReadString('\n')
+
+	client.Write([]byte("/set tz Nowhere/Special\n"))
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "Unknown timezone") {
+		t.Errorf("Expected unknown timezone, got %q", line)
+	}
+	client.Write([]byte("/set timefmt nonsense\n"))
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "Unknown time format") {
+		t.Errorf("Expected unknown time format, got %q", line)
+	}
+
+	client.Write([]byte("/history 1\n"))
+	if line, _ := reader.ReadString('\n'); line != "[22:30:00][alice]: earlier\n" {
+		t.Errorf("Expected history in the chosen format, got %q", line)
+	}
+
+	// Each message is stamped when the server receives it
+	before := time.Now()
+	client.Write([]byte("first\n"))
+	first := nextOfKind(t, hub, models.KindChat)
+	time.Sleep(10 * time.Millisecond)
+	client.Write([]byte("second\n"))
+	second := nextOfKind(t, hub, models.KindChat)
+
+	if first.Time.Before(before) || !second.Time.After(first.Time) {
+		t.Errorf("Expected messages stamped on arrival, got %v and %v", first.Time, second.Time)
+	}
+}
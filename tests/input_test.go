@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	cl "netcat/client"
+	"netcat/input"
+	"netcat/models"
+)
+
+func TestReaderLimits(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	source := "short\r\n" + long + "\nafter\nhéllo wörld\nlast"
+
+	reader := input.NewReader(strings.NewReader(source), 8, input.Reject)
+	expected := []struct {
+		line string
+		err  error
+	}{
+		{"short", nil},
+		{"", input.ErrTooLong},
+		{"after", nil},
+		{"", input.ErrTooLong},
+		{"last", io.EOF},
+	}
+	for _, want := range expected {
+		line, err := reader.ReadLine()
+		if line != want.line || !errors.Is(err, want.err) {
+			t.Errorf("Expected %q, %v; got %q, %v", want.line, want.err, line, err)
+		}
+	}
+
+	// Truncated lines are cut on a character boundary
+	reader = input.NewReader(strings.NewReader(source), 8, input.Truncate)
+	reader.ReadLine()
+	if line, err := reader.ReadLine(); line != "xxxxxxxx" || !errors.Is(err, input.ErrTruncated) {
+		t.Errorf("Expected the long line cut to 8 bytes, got %q, %v", line, err)
+	}
+	reader.ReadLine()
+	if line, err := reader.ReadLine(); line != "héllo w" || !errors.Is(err, input.ErrTruncated) {
+		t.Errorf("Expected the line cut before a split character, got %q, %v", line, err)
+	}
+}
+
+func TestHandleClientLineLimit(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.MaxLine = 16
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go cl.HandleClient(hub, server)
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+
+	client.Write([]byte(strings.Repeat("n", 100) + "\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, "too long") {
+		t.Errorf("Expected a long name to be refused, got %q", line)
+	}
+	reader.ReadString(':')
+	client.Write([]byte("writer\n"))
+	reader.ReadString('\n') // history
+
+	client.Write([]byte(strings.Repeat("word ", 50) + "\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, "longer than 16 bytes") {
+		t.Errorf("Expected the message to be refused, got %q", line)
+	}
+	client.Write([]byte("fits\n"))
+	if msg := nextOfKind(t, hub, models.KindChat); msg.Body != "fits" {
+		t.Errorf("Expected only the short message to be sent, got %q", msg.Body)
+	}
+}
+
+func TestHandleClientTruncatesLongLines(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+	hub.MaxLine = 16
+	hub.Oversize = input.Truncate
+
+	client, reader, _ := joinTestClient(t, hub, "writer")
+	defer client.Close()
+
+	client.Write([]byte("this message will not fit\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, "cut to 16 bytes") {
+		t.Errorf("Expected to be told about the cut, got %q", line)
+	}
+	if msg := nextOfKind(t, hub, models.KindChat); msg.Body != "this message wil" {
+		t.Errorf("Expected the truncated message, got %q", msg.Body)
+	}
+}
+
+func TestHandleClientNameTimeout(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.NameTimeout = 50 * time.Millisecond
+
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		cl.HandleClient(hub, server)
+		close(done)
+	}()
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString(':')
+
+	if line := readUntil(t, reader, "Timed out"); !strings.Contains(line, "Connection closed") {
+		t.Errorf("Expected the idle connection to be told, got %q", line)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the idle connection to be closed")
+	}
+
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+	if len(hub.Clients) != 0 {
+		t.Errorf("Expected no clients, got %v", hub.Clients)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/logfile"
+)
+
+func TestLogFileAppendsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat_log_1234.log")
+
+	for _, line := range []string{"before restart\n", "after restart\n"} {
+		file, err := logfile.Open(path, logfile.Policy{})
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		file.Write([]byte(line))
+		file.Close()
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if string(content) != "before restart\nafter restart\n" {
+		t.Errorf("Expected history to survive a restart, got %q", string(content))
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat_log_1234.log")
+
+	file, err := logfile.Open(path, logfile.Policy{RotateBytes: 12})
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	for _, line := range []string{"message 1\n", "message 2\n", "message 3\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	file.Close()
+
+	segments := logfile.Segments(path)
+	expected := []string{path + ".2", path + ".1", path}
+	if strings.Join(segments, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected segments %q, got %q", expected, segments)
+	}
+	for i, segment := range segments {
+		content, _ := os.ReadFile(segment)
+		if want := "message " + string(rune('1'+i)) + "\n"; string(content) != want {
+			t.Errorf("Segment %s: expected %q, got %q", segment, want, string(content))
+		}
+	}
+}
+
+func TestLogFileRetention(t *testing.T) {
+	t.Run("max lines", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "chat.log")
+		file, err := logfile.Open(path, logfile.Policy{
+			RotateBytes: 6,
+			Retention:   logfile.Retention{MaxLines: 2},
+		})
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+			file.Write([]byte(line))
+		}
+		file.Close()
+
+		if segments := logfile.Segments(path); len(segments) != 2 {
+			t.Errorf("Expected the oldest segments to be removed, got %q", segments)
+		}
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "chat.log")
+		file, err := logfile.Open(path, logfile.Policy{
+			RotateBytes: 10,
+			Retention:   logfile.Retention{MaxBytes: 20},
+		})
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			file.Write([]byte("012345678\n"))
+		}
+		file.Close()
+
+		var total int64
+		for _, segment := range logfile.Segments(path) {
+			info, _ := os.Stat(segment)
+			total += info.Size()
+		}
+		if total > 20 {
+			t.Errorf("Expected at most 20 bytes of history, got %d", total)
+		}
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "chat.log")
+		os.WriteFile(path+".1", []byte("ancient\n"), 0644)
+		old := time.Now().Add(-48 * time.Hour)
+		os.Chtimes(path+".1", old, old)
+
+		file, err := logfile.Open(path, logfile.Policy{Retention: logfile.Retention{MaxAge: 24 * time.Hour}})
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		file.Close()
+
+		if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+			t.Error("Expected the expired segment to be deleted on open")
+		}
+	})
+}
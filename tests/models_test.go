@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"netcat/models"
+)
+
+func TestMessageRender(t *testing.T) {
+	at := time.Date(2025, 3, 1, 22, 30, 0, 0, time.UTC)
+	prefs := models.Prefs{Location: time.UTC}
+
+	tests := []struct {
+		name     string
+		msg      models.Message
+		viewer   string
+		expected string
+	}{
+		{"chat", models.Message{Kind: models.KindChat, Sender: "alice", Body: "hi", Time: at}, "bob", "[2025-03-01 22:30:00][alice]: hi\n"},
+		{"chat echo", models.Message{Kind: models.KindChat, Sender: "alice", Body: "hi", Time: at}, "alice", "[2025-03-01 22:30:00][alice]: hi\n"},
+		{"connect", models.Message{Kind: models.KindJoin, Sender: "alice", Room: "lobby"}, "bob", "alice has joined our chat...\n"},
+		{"own join", models.Message{Kind: models.KindJoin, Sender: "alice", Room: "lobby"}, "alice", ""},
+		{"room join", models.Message{Kind: models.KindJoin, Sender: "alice", Room: "dev", Meta: map[string]string{"from": "lobby"}}, "bob", "alice has joined #dev...\n"},
+		{"disconnect", models.Message{Kind: models.KindLeave, Sender: "alice", Room: "lobby"}, "bob", "alice has left our chat.\n"},
+		{"room leave", models.Message{Kind: models.KindLeave, Sender: "alice", Room: "lobby", Meta: map[string]string{"to": "dev"}}, "bob", "alice has left #lobby.\n"},
+		{"rename", models.Message{Kind: models.KindRename, Sender: "alicia", Meta: map[string]string{"old": "alice"}}, "bob", "alice has changed their name to alicia\n"},
+		{"dm received", models.Message{Kind: models.KindDirect, Sender: "alice", Body: "psst", Time: at, Meta: map[string]string{"to": "bob"}}, "bob", "[2025-03-01 22:30:00][DM from alice]: psst\n"},
+		{"dm sent", models.Message{Kind: models.KindDirect, Sender: "alice", Body: "psst", Time: at, Meta: map[string]string{"to": "bob"}}, "alice", "[2025-03-01 22:30:00][DM to bob]: psst\n"},
+		{"system", models.Message{Body: "Server is shutting down"}, "bob", "Server is shutting down\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msg.Render(tt.viewer, prefs); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMessageLine(t *testing.T) {
+	msg := models.Message{Kind: models.KindChat, Sender: "alice", Body: "hi", Time: time.Date(2025, 3, 1, 23, 30, 0, 0, time.FixedZone("CET", 3600))}
+	if expected := "[2025-03-01T22:30:00Z][alice]: hi\n"; msg.Line() != expected {
+		t.Errorf("Expected %q, got %q", expected, msg.Line())
+	}
+
+	join := models.Message{Kind: models.KindJoin, Sender: "alice", Room: "lobby"}
+	if expected := "alice has joined our chat...\n"; join.Line() != expected {
+		t.Errorf("Expected %q, got %q", expected, join.Line())
+	}
+}
+
+func TestHubNextID(t *testing.T) {
+	hub := models.NewHub()
+	first, second := hub.NextID(), hub.NextID()
+	if first == 0 || second <= first {
+		t.Errorf("Expected increasing non-zero IDs, got %d and %d", first, second)
+	}
+}
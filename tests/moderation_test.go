@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netcat/bans"
+	"netcat/server"
+)
+
+func TestBanListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+
+	list, err := bans.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	now := time.Now()
+	list.Add(bans.Entry{Name: "Mallory", By: "op", Time: now})
+	list.Add(bans.Entry{IP: "10.0.0.1", By: "op", Time: now, Until: now.Add(time.Hour), Reason: "flooding"})
+	list.Add(bans.Entry{Name: "expired", By: "op", Time: now, Until: now.Add(-time.Minute)})
+
+	reopened, err := bans.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if _, ok := reopened.Name("mallory"); !ok {
+		t.Error("Expected the name ban to survive, ignoring case")
+	}
+	if entry, ok := reopened.IP("10.0.0.1"); !ok || entry.Reason != "flooding" {
+		t.Errorf("Expected the IP ban to survive, got %+v", entry)
+	}
+	if _, ok := reopened.Name("expired"); ok {
+		t.Error("Expired bans must not apply")
+	}
+
+	if removed, err := reopened.Remove("MALLORY"); !removed || err != nil {
+		t.Errorf("Expected the ban to be lifted, got %v %v", removed, err)
+	}
+	reopened, _ = bans.Open(path)
+	if len(reopened.Entries()) != 1 {
+		t.Errorf("Expected one ban left on disk, got %+v", reopened.Entries())
+	}
+}
+
+func TestOperatorCommands(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	banFile := filepath.Join(t.TempDir(), "bans.json")
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0),
+		server.WithOpPassword("secret"), server.WithBanFile(banFile))
+	addr := startTestServer(t, srv)
+	defer srv.Shutdown(context.Background())
+
+	op, opReader, _ := dialTestServer(t, addr, ':')
+	defer op.Close()
+	op.Write([]byte("alice\n"))
+
+	user, userReader, _ := dialTestServer(t, addr, ':')
+	defer user.Close()
+	user.Write([]byte("bob\n"))
+	readUntil(t, opReader, "bob has joined")
+
+	op.Write([]byte("/kick bob\n"))
+	readUntil(t, opReader, "You are not an operator.")
+	op.Write([]byte("/op guess\n"))
+	readUntil(t, opReader, "Wrong operator password.")
+	op.Write([]byte("/op secret\n"))
+	readUntil(t, opReader, "You are now an operator.")
+
+	op.Write([]byte("/mute bob 1m\n"))
+	readUntil(t, opReader, "bob was muted by alice for 1m")
+	user.Write([]byte("can anyone hear me\n"))
+	readUntil(t, userReader, "You are muted for another")
+	user.Write([]byte("/msg alice psst\n"))
+	readUntil(t, userReader, "You are muted for another")
+
+	op.Write([]byte("/unmute bob\n"))
+	readUntil(t, opReader, "bob was unmuted by alice")
+	user.Write([]byte("thanks\n"))
+	readUntil(t, opReader, "[bob]: thanks")
+
+	op.Write([]byte("/kick nobody\n"))
+	readUntil(t, opReader, "No user named nobody is connected.")
+	op.Write([]byte("/kick bob spamming\n"))
+	readUntil(t, opReader, "bob was kicked by alice: spamming")
+	readUntil(t, userReader, "You have been kicked by alice: spamming.")
+	if _, err := io.ReadAll(userReader); err != nil {
+		t.Errorf("Expected the kicked user to be disconnected, got %v", err)
+	}
+
+	// A banned name is refused at the name prompt, even after a restart
+	op.Write([]byte("/ban bob 1h rude\n"))
+	readUntil(t, opReader, "bob was banned by alice for 1h: rude")
+
+	again, againReader, _ := dialTestServer(t, addr, ':')
+	defer again.Close()
+	again.Write([]byte("BOB\n"))
+	readUntil(t, againReader, "You are banned from this server until")
+
+	saved, err := bans.Open(banFile)
+	if err != nil {
+		t.Fatalf("Failed to read ban file: %v", err)
+	}
+	if entry, ok := saved.Name("bob"); !ok || entry.By != "alice" || entry.Reason != "rude" {
+		t.Errorf("Expected the ban to be saved, got %+v", entry)
+	}
+
+	op.Write([]byte("/unban bob\n"))
+	readUntil(t, opReader, "bob was unbanned by alice")
+	op.Write([]byte("/unban bob\n"))
+	readUntil(t, opReader, "No such ban.")
+}
+
+func TestBannedAddressRefusedAtAccept(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	banFile := filepath.Join(t.TempDir(), "bans.json")
+	list, _ := bans.Open(banFile)
+	list.Add(bans.Entry{IP: "127.0.0.1", By: "alice", Time: time.Now()})
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithBanFile(banFile))
+	addr := startTestServer(t, srv)
+	defer srv.Shutdown(context.Background())
+
+	conn, _, text := dialTestServer(t, addr, '\n')
+	defer conn.Close()
+	if text != "You are banned from this server.\n" {
+		t.Errorf("Expected the ban notice instead of the banner, got %q", text)
+	}
+}
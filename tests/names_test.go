@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"netcat/models"
+	"netcat/names"
+)
+
+func TestValidateName(t *testing.T) {
+	rules := models.NameRules{
+		MinLength:          2,
+		MaxLength:          8,
+		AllowedPunctuation: "_",
+		Reserved:           []string{"server"},
+	}
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"bob", true},
+		{"Émile_2", true},
+		{"b", false},
+		{"waytoolongname", false},
+		{"two words", false},
+		{"dash-ed", false},
+		{"\x1b[31mred", false},
+		{"SERVER", false},
+	}
+
+	for _, tc := range tests {
+		err := names.Validate(rules, tc.name)
+		if tc.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, names.ErrInvalidName) {
+			t.Errorf("Expected %q to be invalid, got %v", tc.name, err)
+		}
+	}
+
+	if err := names.Validate(rules, "Server"); !errors.Is(err, names.ErrNameReserved) {
+		t.Errorf("Expected reserved name error, got %v", err)
+	}
+}
+
+func TestClaimName(t *testing.T) {
+	hub := models.NewHub()
+
+	server1, client1 := net.Pipe()
+	server2, client2 := net.Pipe()
+	defer server1.Close()
+	defer client1.Close()
+	defer server2.Close()
+	defer client2.Close()
+
+	if _, err := names.Claim(hub, server1, "Alice"); err != nil {
+		t.Fatalf("Failed to claim a free name: %v", err)
+	}
+	if _, err := names.Claim(hub, server2, "aLiCe"); !errors.Is(err, names.ErrNameTaken) {
+		t.Errorf("Expected case-insensitive duplicate to be refused, got %v", err)
+	}
+
+	// Changing only the case of your own name is allowed
+	previous, err := names.Claim(hub, server1, "ALICE")
+	if err != nil {
+		t.Fatalf("Expected to be able to recase own name, got %v", err)
+	}
+	if previous != "Alice" {
+		t.Errorf("Expected previous name %q, got %q", "Alice", previous)
+	}
+	if hub.Name(server1) != "ALICE" {
+		t.Errorf("Expected name to be updated, got %q", hub.Name(server1))
+	}
+}
+
+func TestRenameHistory(t *testing.T) {
+	hub := models.NewHub()
+	hub.RenameHistory = 3
+	start := time.Now()
+
+	hub.Mu.Lock()
+	names.Record(hub, "alice", "alicia", start)
+	names.Record(hub, "bob", "robert", start.Add(time.Second))
+	names.Record(hub, "alicia", "ally", start.Add(2*time.Second))
+	changes, current := names.History(hub, "ALICE")
+	hub.Mu.Unlock()
+
+	if len(changes) != 1 || changes[0].New != "alicia" {
+		t.Errorf("Expected one change from alice, got %+v", changes)
+	}
+	if current != "ally" {
+		t.Errorf("Expected alice to be known as ally now, got %q", current)
+	}
+
+	// The oldest change is forgotten once the history is full
+	hub.Mu.Lock()
+	names.Record(hub, "carol", "caroline", start.Add(3*time.Second))
+	changes, current = names.History(hub, "alice")
+	hub.Mu.Unlock()
+	if len(changes) != 0 || current != "alice" {
+		t.Errorf("Expected the change from alice to be forgotten, got %+v and %q", changes, current)
+	}
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/outbox"
+	"netcat/server"
+)
+
+func TestOutboxDelivers(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	box := outbox.New(server, outbox.Config{Size: 4, Policy: outbox.DropOldest, WriteTimeout: time.Second})
+
+	box.Send("one\n")
+	box.Send("two\n")
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, expected := range []string{"one\n", "two\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != expected {
+			t.Errorf("Expected %q, got %q (err: %v)", expected, line, err)
+		}
+	}
+
+	box.Close()
+	if box.Send("three\n") {
+		t.Error("Send should report false after Close")
+	}
+}
+
+func TestOutboxOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy   outbox.Policy
+		expected []string
+		alive    bool
+	}{
+		{outbox.DropOldest, []string{"3\n", "4\n"}, true},
+		{outbox.DropNewest, []string{"1\n", "2\n"}, true},
+		{outbox.Disconnect, nil, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+
+			box := outbox.New(server, outbox.Config{Size: 2, Policy: tc.policy, WriteTimeout: time.Second})
+
+			// The writer picks up "0" and blocks on the pipe, so "1" and "2"
+			// fill the queue and the rest overflow
+			box.Send("0\n")
+			time.Sleep(50 * time.Millisecond)
+
+			results := []bool{}
+			for _, msg := range []string{"1\n", "2\n", "3\n", "4\n"} {
+				results = append(results, box.Send(msg))
+			}
+
+			if tc.alive != results[len(results)-1] {
+				t.Errorf("Expected Send to report %v after overflow, got %v", tc.alive, results)
+			}
+			if tc.alive && box.Dropped() != 2 {
+				t.Errorf("Expected 2 dropped messages, got %d", box.Dropped())
+			}
+
+			reader := bufio.NewReader(client)
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			var received []string
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				received = append(received, line)
+				if len(received) == len(tc.expected)+1 {
+					box.Close()
+				}
+			}
+
+			if tc.alive {
+				if got := strings.Join(received, ""); got != "0\n"+strings.Join(tc.expected, "") {
+					t.Errorf("Expected to receive %q after 0, got %q", tc.expected, received)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxStuckReaderTimesOut(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	box := outbox.New(server, outbox.Config{Size: 4, Policy: outbox.DropOldest, WriteTimeout: 50 * time.Millisecond})
+	box.Send("nobody is reading\n")
+
+	select {
+	case <-box.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Writer should give up on a client that stops reading")
+	}
+	if box.Send("more\n") {
+		t.Error("Send should report false once the client timed out")
+	}
+}
+
+func TestIdleClientGetsReplies(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	cfg := outbox.DefaultConfig
+	cfg.WriteTimeout = 200 * time.Millisecond
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithOutbox(cfg))
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	defer alice.Close()
+	alice.Write([]byte("alice\n"))
+	bob, _, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+	readUntil(t, aliceReader, "bob has joined")
+
+	// The deadline of the last broadcast must not outlive it
+	time.Sleep(3 * cfg.WriteTimeout)
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	alice.Write([]byte("/who\n"))
+	readUntil(t, aliceReader, "Users online (2):")
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/server"
+)
+
+func TestWhoAndWhois(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithOpPassword("secret"))
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	bob, bobReader, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+	bob.Write([]byte("/join dev\n"))
+	readUntil(t, bobReader, "You joined #dev.")
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	defer alice.Close()
+	alice.Write([]byte("alice\n"))
+
+	alice.Write([]byte("/who\n"))
+	readUntil(t, aliceReader, "Users online (2):")
+	first := readUntil(t, aliceReader, "\n")
+	second := readUntil(t, aliceReader, "\n")
+	if !strings.Contains(first, "alice") || !strings.Contains(first, "#lobby") || !strings.Contains(first, "idle 0s") {
+		t.Errorf("Expected alice first, in #lobby and not idle, got %q", first)
+	}
+	if !strings.Contains(second, "bob") || !strings.Contains(second, "#dev") {
+		t.Errorf("Expected bob in #dev, got %q", second)
+	}
+
+	// Only operators see where a user connects from
+	alice.Write([]byte("/whois BOB\n"))
+	readUntil(t, aliceReader, "bob\n")
+	readUntil(t, aliceReader, "Joined:")
+	readUntil(t, aliceReader, "Connected:")
+	readUntil(t, aliceReader, "Room:      #dev")
+	if line := readUntil(t, aliceReader, "\n"); !strings.Contains(line, "Idle:") {
+		t.Errorf("Expected the idle time last, got %q", line)
+	}
+	alice.Write([]byte("/op secret\n"))
+	readUntil(t, aliceReader, "You are now an operator.")
+	alice.Write([]byte("/whois bob\n"))
+	readExpecting(t, aliceReader, "Address:   127.0.0.1:", "You are now")
+
+	alice.Write([]byte("/whois carol\n"))
+	readUntil(t, aliceReader, "No user named carol is connected.")
+
+	// Idle time counts from the last line sent
+	time.Sleep(1100 * time.Millisecond)
+	bob.Write([]byte("/who\n"))
+	readUntil(t, bobReader, "Users online (2):")
+	if line := readUntil(t, bobReader, "\n"); !strings.Contains(line, "idle 1s") || !strings.Contains(line, "(operator)") {
+		t.Errorf("Expected alice idle for 1s and marked as operator, got %q", line)
+	}
+	if line := readUntil(t, bobReader, "\n"); !strings.Contains(line, "idle 0s") {
+		t.Errorf("Expected bob not idle, got %q", line)
+	}
+}
+
+func TestAwayStatus(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithAutoAway(300*time.Millisecond))
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	defer alice.Close()
+	alice.Write([]byte("alice\n"))
+
+	bob, bobReader, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+	readUntil(t, aliceReader, "bob has joined")
+
+	alice.Write([]byte("/away at lunch\n"))
+	readUntil(t, aliceReader, "You are marked as away (at lunch).")
+
+	// Messaging or mentioning alice tells the sender they are away
+	bob.Write([]byte("/msg alice are you there?\n"))
+	readUntil(t, bobReader, "[DM to alice]: are you there?")
+	readUntil(t, bobReader, "[alice is away: at lunch]")
+	bob.Write([]byte("anyone seen Alice today?\n"))
+	readUntil(t, bobReader, "[alice is away: at lunch]")
+	bob.Write([]byte("alicegram is a nice name\n"))
+	readExpecting(t, bobReader, "alicegram is a nice name", "is away")
+
+	bob.Write([]byte("/who\n"))
+	readUntil(t, bobReader, "Users online (2):")
+	if line := readUntil(t, bobReader, "\n"); !strings.Contains(line, "(away: at lunch)") {
+		t.Errorf("Expected alice to be marked as away, got %q", line)
+	}
+	bob.Write([]byte("/whois alice\n"))
+	readUntil(t, bobReader, "Away:      at lunch")
+
+	alice.Write([]byte("/back\n"))
+	readUntil(t, aliceReader, "You are no longer marked as away.")
+	alice.Write([]byte("/back\n"))
+	readUntil(t, aliceReader, "You are not marked as away.")
+
+	// Staying quiet marks a user away until they send something
+	readUntil(t, bobReader, "[You were marked as away after 300ms without activity.]")
+	alice.Write([]byte("/msg bob ping\n"))
+	readUntil(t, aliceReader, "[bob is away: Idle]")
+	bob.Write([]byte("pong\n"))
+	readUntil(t, bobReader, "[You are no longer marked as away.]")
+	alice.Write([]byte("/msg bob ping\n"))
+	readExpecting(t, aliceReader, "[DM to bob]: ping", "is away")
+	alice.Write([]byte("/who\n"))
+	readUntil(t, aliceReader, "Users online (2):")
+	readUntil(t, aliceReader, "\n")
+	if line := readUntil(t, aliceReader, "\n"); strings.Contains(line, "away") {
+		t.Errorf("Expected bob to be back, got %q", line)
+	}
+}
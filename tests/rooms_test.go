@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	br "netcat/broadcast"
+	cl "netcat/client"
+	"netcat/models"
+	"netcat/rooms"
+)
+
+func TestJoinAndList(t *testing.T) {
+	hub := models.NewHub()
+
+	server1, client1 := net.Pipe()
+	server2, client2 := net.Pipe()
+	defer server1.Close()
+	defer client1.Close()
+	defer server2.Close()
+	defer client2.Close()
+
+	if _, previous, err := rooms.Join(hub, server1, models.Lobby); err != nil || previous != "" {
+		t.Fatalf("Expected first join to succeed with no previous room, got previous=%q err=%v", previous, err)
+	}
+	rooms.Join(hub, server2, models.Lobby)
+
+	room, previous, err := rooms.Join(hub, server1, "#Games")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	if room.Name != "games" {
+		t.Errorf("Expected normalized room name %q, got %q", "games", room.Name)
+	}
+	if previous != models.Lobby {
+		t.Errorf("Expected previous room %q, got %q", models.Lobby, previous)
+	}
+	if _, inLobby := hub.Rooms[models.Lobby].Members[server1]; inLobby {
+		t.Error("Client should have left the lobby when switching rooms")
+	}
+
+	expected := []string{"#games (1) *", "#lobby (1)"}
+	got := rooms.List(hub, server1)
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected room list %q, got %q", expected, got)
+	}
+
+	hub.Mu.Lock()
+	left := rooms.Remove(hub, server1)
+	hub.Mu.Unlock()
+	if left != "games" {
+		t.Errorf("Expected to leave %q, got %q", "games", left)
+	}
+	if len(hub.Rooms["games"].Members) != 0 {
+		t.Error("Room should be empty after its only member left")
+	}
+}
+
+func TestJoinInvalidName(t *testing.T) {
+	hub := models.NewHub()
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	for _, name := range []string{"", "#", "has space", "semi;colon", "../escape", strings.Repeat("a", rooms.MaxNameLength+1)} {
+		if _, _, err := rooms.Join(hub, server, name); !errors.Is(err, rooms.ErrInvalidName) {
+			t.Errorf("Expected ErrInvalidName for %q, got %v", name, err)
+		}
+	}
+	if len(hub.Rooms) != 1 {
+		t.Errorf("Invalid names should not create rooms, got %d rooms", len(hub.Rooms))
+	}
+}
+
+func TestJoinOpensRoomLog(t *testing.T) {
+	hub := models.NewHub()
+	var opened []string
+	hub.OpenRoomLog = func(room string) (io.Writer, string, error) {
+		opened = append(opened, room)
+		return ioutil.Discard, room + ".log", nil
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	room, _, err := rooms.Join(hub, server, "dev")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	rooms.Join(hub, server, "dev")
+
+	if len(opened) != 1 || opened[0] != "dev" {
+		t.Errorf("Expected the log to be opened once for %q, got %q", "dev", opened)
+	}
+	if room.HistoryFile != "dev.log" {
+		t.Errorf("Expected history file %q, got %q", "dev.log", room.HistoryFile)
+	}
+}
+
+func TestDropEmptyRoom(t *testing.T) {
+	hub := models.NewHub()
+	var closed []io.Writer
+	hub.OpenRoomLog = func(room string) (io.Writer, string, error) {
+		return &strings.Builder{}, "", nil
+	}
+	hub.CloseRoomLog = func(w io.Writer) {
+		closed = append(closed, w)
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	dev, _, err := rooms.Join(hub, server, "dev")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	hub.Mu.Lock()
+	if rooms.Drop(hub, "dev") != nil {
+		t.Error("Expected a room with members to stay")
+	}
+	rooms.Remove(hub, server)
+	if rooms.Drop(hub, models.Lobby) != nil {
+		t.Error("Expected the lobby never to be removed")
+	}
+
+	// A dropped client may still come back to the room
+	hub.Detached["token"] = &models.Session{Name: "alice", Room: "dev"}
+	if rooms.Drop(hub, "dev") != nil {
+		t.Error("Expected a room with a waiting session to stay")
+	}
+	delete(hub.Detached, "token")
+
+	dropped := rooms.Drop(hub, "dev")
+	_, exists := hub.Rooms["dev"]
+	hub.Mu.Unlock()
+	if dropped != dev || exists {
+		t.Fatal("Expected the empty room to be removed")
+	}
+
+	rooms.CloseLog(hub, dropped)
+	if len(closed) != 1 || closed[0] != dev.LogFile {
+		t.Errorf("Expected the room's log to be closed, got %v", closed)
+	}
+}
+
+func TestHandleClientJoinRoom(t *testing.T) {
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	err := ioutil.WriteFile("logo.txt", []byte("Welcome!"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	// A lobby member who should hear the departure but not the room chatter
+	lobbyServer, lobbyClient := net.Pipe()
+	defer lobbyServer.Close()
+	defer lobbyClient.Close()
+	hub.Clients[lobbyServer] = models.NewClient(lobbyServer, "Watcher")
+	rooms.Join(hub, lobbyServer, models.Lobby)
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go br.Broadcaster(hub)
+	go cl.HandleClient(hub, server)
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	reader.ReadString(':')
+
+	lobbyReader := bufio.NewReader(lobbyClient)
+	lobbyClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	client.Write([]byte("Mover\n"))
+	reader.ReadString('\n') // no chat history
+	if line, _ := lobbyReader.ReadString('\n'); !strings.Contains(line, "Mover has joined our chat") {
+		t.Errorf("Expected lobby join notice, got %q", line)
+	}
+
+	client.Write([]byte("/join dev\n"))
+	if line, _ := lobbyReader.ReadString('\n'); line != "Mover has left #lobby.\n" {
+		t.Errorf("Expected lobby leave notice, got %q", line)
+	}
+	if line, _ := reader.ReadString('\n'); line != "You joined #dev.\n" {
+		t.Errorf("Expected join confirmation, got %q", line)
+	}
+	reader.ReadString('\n') // no chat history
+
+	client.Write([]byte("hello dev\n"))
+	if line, _ := reader.ReadString('\n'); !strings.HasSuffix(line, "[Mover]: hello dev\n") {
+		t.Errorf("Expected the room message, got %q", line)
+	}
+
+	client.Write([]byte("/rooms\n"))
+	var listing strings.Builder
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read room list: %v", err)
+		}
+		listing.WriteString(line)
+	}
+	if !strings.Contains(listing.String(), "#dev (1) *") || !strings.Contains(listing.String(), "#lobby (1)") {
+		t.Errorf("Unexpected room list: %q", listing.String())
+	}
+
+	client.Write([]byte("/leave\n"))
+	if line, _ := reader.ReadString('\n'); line != "You joined #lobby.\n" {
+		t.Errorf("Expected to return to the lobby, got %q", line)
+	}
+	go io.Copy(io.Discard, reader) // lobby history
+
+	// The watcher never heard the room chatter, only the return
+	if line, _ := lobbyReader.ReadString('\n'); line != "Mover has joined #lobby...\n" {
+		t.Errorf("Expected lobby return notice, got %q", line)
+	}
+
+	// The departure from #dev was announced first, so the empty room is gone
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+	if _, exists := hub.Rooms["dev"]; exists {
+		t.Error("Expected the empty room to be removed")
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"os"
+	"testing"
+
+	"netcat/models"
+	"netcat/sanitize"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain text", "hello, wörld", "hello, wörld"},
+		{"clear screen", "\x1b[2J\x1b[Hhi", "hi"},
+		{"colors removed", "\x1b[31mred\x1b[0m", "red"},
+		{"bold removed", "\x1b[1;31mbold red", "bold red"},
+		{"blink removed", "\x1b[5mblink", "blink"},
+		{"window title", "\x1b]0;pwned\x07after", "after"},
+		{"title ended by ST", "\x1b]2;pwned\x1b\\after", "after"},
+		{"8-bit CSI", "a\u009b2Jb", "ab"},
+		{"8-bit OSC", "a\u009d0;x\u009cb", "ab"},
+		{"reset terminal", "a\x1bcb", "ab"},
+		{"charset switch", "a\x1b(0b", "ab"},
+		{"unterminated", "hi\x1b[31", "hi"},
+		{"carriage return spoof", "ok\r[Server]: bye", "ok[Server]: bye"},
+		{"bell and backspace", "a\a\bb\x7f", "ab"},
+		{"tab", "a\tb", "a b"},
+		{"invalid utf-8", "a\xffb", "a�b"},
+		{"lone escape", "a\x1b", "a"},
+	}
+	for _, tt := range tests {
+		if got := sanitize.Clean(tt.input); got != tt.want {
+			t.Errorf("%s: Clean(%q) = %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestHandleClientSanitizesInput(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	client, _, _ := joinTestClient(t, hub, "\x1b[31mmallory\x1b[0m")
+	defer client.Close()
+
+	client.Write([]byte("\x1b[2J\x1b[Hhello\r[Server]: everyone has been banned\x07\n"))
+	msg := nextOfKind(t, hub, models.KindChat)
+	if msg.Sender != "mallory" {
+		t.Errorf("Expected escapes to be removed from the name, got %q", msg.Sender)
+	}
+	if msg.Body != "hello[Server]: everyone has been banned" {
+		t.Errorf("Expected control characters to be removed, got %q", msg.Body)
+	}
+
+	// Colors never reach other users or the logs
+	client.Write([]byte("\x1b[1;31mred alert\x1b[0m\n"))
+	if msg := nextOfKind(t, hub, models.KindChat); msg.Body != "red alert" {
+		t.Errorf("Expected colors to be removed, got %q", msg.Body)
+	}
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -15,16 +16,7 @@ import (
 	"time"
 )
 
-func TestInitServer(t *testing.T) {
-	originalLogFile := models.LogFile
-	originalClients := make(map[net.Conn]string)
-	if models.Clients != nil {
-		models.Mu.Lock()
-		for k, v := range models.Clients {
-			originalClients[k] = v
-		}
-		models.Mu.Unlock()
-	}
+func TestListenAndServe(t *testing.T) {
 	baseDir, err := os.Getwd()
 	if err != nil {
 		t.Fatalf("Failed to get current directory: %v", err)
@@ -35,13 +27,7 @@ func TestInitServer(t *testing.T) {
 	log.SetOutput(io.Discard)
 	defer log.SetOutput(originalStdLogOutput)
 
-	defer func() {
-		models.LogFile = originalLogFile
-		models.Mu.Lock()
-		models.Clients = originalClients
-		models.Mu.Unlock()
-		os.RemoveAll(testLogsPath)
-	}()
+	defer os.RemoveAll(testLogsPath)
 
 	tests := []struct {
 		name         string
@@ -65,10 +51,6 @@ func TestInitServer(t *testing.T) {
 				}
 
 				return func(t *testing.T) {
-					if models.LogFile != nil {
-						models.LogFile.Close()
-						models.LogFile = nil
-					}
 					os.Remove("logo.txt")
 				}
 			},
@@ -116,14 +98,8 @@ func TestInitServer(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			// Reset global state that InitServer modifies
-			models.Mu.Lock()
-			models.Clients = make(map[net.Conn]string)
-			models.Mu.Unlock()
-			if models.LogFile != nil { // Close any previously opened global log file
-				models.LogFile.Close()
-			}
-			models.LogFile = nil
+			srv := server.New(server.WithLogDir(testLogsPath), server.WithShutdownGrace(0))
+			defer srv.Shutdown(context.Background())
 
 			cleanup := tc.setup(t)
 			defer cleanup(t)
@@ -133,17 +109,17 @@ func TestInitServer(t *testing.T) {
 
 			go func() {
 				defer close(serverExitedChan)
-				err := server.InitServer(tc.port)
-				if err != nil {
+				err := srv.ListenAndServe(tc.port)
+				if err != nil && err != server.ErrServerClosed {
 					errChan <- err
 				}
 			}()
 
 			if !tc.expectErr {
-				// Success case: InitServer should not return an error and should be running.
+				// Success case: ListenAndServe should not return an error and should be running.
 				select {
 				case err := <-errChan:
-					t.Fatalf("InitServer returned an error unexpectedly: %v", err)
+					t.Fatalf("ListenAndServe returned an error unexpectedly: %v", err)
 				case <-time.After(250 * time.Millisecond): // Give server time to start up
 					if tc.validateFunc != nil {
 						tc.validateFunc(t, tc.port, testLogsPath)
@@ -151,32 +127,299 @@ func TestInitServer(t *testing.T) {
 					// Server goroutine is still running. Test will end, OS cleans up port.
 				case <-serverExitedChan:
 					// This means server exited cleanly, which is not expected for a successful persistent server start
-					t.Fatal("InitServer exited unexpectedly for a success case (should run indefinitely)")
+					t.Fatal("ListenAndServe exited unexpectedly for a success case (should run indefinitely)")
 				}
 			} else {
-				// Error case: InitServer should return an error.
+				// Error case: ListenAndServe should return an error.
 				select {
 				case err := <-errChan:
 					if err == nil {
-						t.Errorf("Expected an error from InitServer, but got nil")
+						t.Errorf("Expected an error from ListenAndServe, but got nil")
 					}
 				case <-time.After(1 * time.Second):
-					t.Errorf("Expected InitServer to return an error, but it timed out")
+					t.Errorf("Expected ListenAndServe to return an error, but it timed out")
 				case <-serverExitedChan:
-					// If serverExitedChan is closed, it means InitServer() returned.
+					// If serverExitedChan is closed, it means ListenAndServe() returned.
 					// We need to check if an error was actually sent to errChan.
 					select {
 					case err := <-errChan:
 						if err == nil {
-							t.Errorf("InitServer exited cleanly, but an error was expected.")
+							t.Errorf("ListenAndServe exited cleanly, but an error was expected.")
 						}
 						// Error received as expected.
 					default:
 						// This case should ideally not be hit if serverExitedChan implies an error was sent or it exited cleanly.
-						t.Errorf("InitServer exited, but no error was received on errChan, though an error was expected.")
+						t.Errorf("ListenAndServe exited, but no error was received on errChan, though an error was expected.")
 					}
 				}
 			}
 		})
 	}
 }
+
+func TestServersRunSideBySide(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	var logA, logB strings.Builder
+	srvA := server.New(server.WithLogWriter(&logA), server.WithShutdownGrace(0))
+	srvB := server.New(server.WithLogWriter(&logB), server.WithShutdownGrace(0))
+
+	for _, srv := range []*server.Server{srvA, srvB} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		go srv.Serve(ln)
+	}
+	defer srvA.Shutdown(context.Background())
+	defer srvB.Shutdown(context.Background())
+
+	waitForAddr := func(srv *server.Server) string {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if addr := srv.Addr(); addr != nil {
+				return addr.String()
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Server did not start listening")
+		return ""
+	}
+
+	join := func(addr, name string) (net.Conn, *bufio.Reader) {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			t.Fatalf("Failed to connect to %s: %v", addr, err)
+		}
+		reader := bufio.NewReader(conn)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := reader.ReadString(':'); err != nil {
+			t.Fatalf("Failed to read name prompt: %v", err)
+		}
+		conn.Write([]byte(name + "\n"))
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatalf("Failed to read history: %v", err)
+		}
+		return conn, reader
+	}
+
+	connA, _ := join(waitForAddr(srvA), "alice")
+	defer connA.Close()
+	connB, readerB := join(waitForAddr(srvB), "bob")
+	defer connB.Close()
+
+	connA.Write([]byte("only for server A\n"))
+
+	connB.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if line, err := readerB.ReadString('\n'); err == nil {
+		t.Errorf("Client on server B received a message from server A: %q", line)
+	}
+
+	srvB.Hub().Mu.Lock()
+	for _, client := range srvB.Hub().Clients {
+		if client.Name != "bob" {
+			t.Errorf("Server B should only know bob, found %q", client.Name)
+		}
+	}
+	srvB.Hub().Mu.Unlock()
+}
+
+func TestShutdown(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	const notice = "Maintenance restart, bye!\n"
+	var logBuf strings.Builder
+	srv := server.New(
+		server.WithLogWriter(&logBuf),
+		server.WithShutdownGrace(200*time.Millisecond),
+		server.WithShutdownNotice(notice),
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadString(':'); err != nil {
+		t.Fatalf("Failed to read name prompt: %v", err)
+	}
+	conn.Write([]byte("alice\n"))
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read shutdown notice: %v", err)
+	}
+	if line != notice {
+		t.Errorf("Expected shutdown notice %q, got %q", notice, line)
+	}
+
+	// New connections are refused as soon as shutdown starts
+	if c, err := net.DialTimeout("tcp", ln.Addr().String(), 100*time.Millisecond); err == nil {
+		c.Close()
+		t.Error("Expected new connections to be refused during shutdown")
+	}
+
+	// The client stays connected for the grace period, then is disconnected
+	if _, err := io.ReadAll(reader); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			t.Error("Client connection was not closed by Shutdown")
+		}
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown returned an error: %v", err)
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != server.ErrServerClosed {
+			t.Errorf("Expected Serve to return ErrServerClosed, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+
+	// The notice is not chat, so it must not be replayed after a restart
+	if strings.Contains(logBuf.String(), notice) {
+		t.Errorf("Expected the shutdown notice not to be logged, got: %q", logBuf.String())
+	}
+	if lines := srv.Hub().Rooms[models.Lobby].History.Last(0); strings.Contains(strings.Join(lines, ""), notice) {
+		t.Errorf("Expected the shutdown notice not to be kept in history, got: %q", lines)
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(time.Minute))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve(ln)
+	for srv.Addr() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Shutdown should give up when its context expires")
+	}
+
+	// The grace period is cut short by the expired context
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected the background shutdown to finish, got: %v", err)
+	}
+}
+
+func TestConnectionLimitAndWaitQueue(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(
+		server.WithLogWriter(io.Discard),
+		server.WithShutdownGrace(0),
+		server.WithMaxClients(1),
+		server.WithWaitQueue(1),
+	)
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	// The slot is taken at accept time, before a name is chosen
+	first, firstReader, _ := dialTestServer(t, addr, '\n')
+	defer first.Close()
+	if prompt, _ := firstReader.ReadString(':'); !strings.Contains(prompt, "[ENTER YOUR NAME]:") {
+		t.Fatalf("Expected name prompt, got %q", prompt)
+	}
+
+	second, secondReader, line := dialTestServer(t, addr, '\n')
+	defer second.Close()
+	if !strings.Contains(line, "You are #1 in line") {
+		t.Errorf("Expected second client to wait in line, got %q", line)
+	}
+
+	third, _, line := dialTestServer(t, addr, '\n')
+	defer third.Close()
+	if line != "Chatroom full...\n" {
+		t.Errorf("Expected third client to be turned away, got %q", line)
+	}
+
+	// When the first client leaves, the second gets its slot
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if logo, err := secondReader.ReadString('\n'); err != nil || !strings.Contains(logo, "Welcome") {
+		t.Errorf("Expected waiting client to be served, got %q (err: %v)", logo, err)
+	}
+}
+
+func TestAdminSlot(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(
+		server.WithLogWriter(io.Discard),
+		server.WithShutdownGrace(0),
+		server.WithMaxClients(1),
+		server.WithAdminSlot("127.0.0.1"),
+	)
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	first, _, _ := dialTestServer(t, addr, '\n')
+	defer first.Close()
+
+	admin, _, line := dialTestServer(t, addr, '\n')
+	defer admin.Close()
+	if !strings.Contains(line, "Welcome") {
+		t.Errorf("Expected the admin host to use the reserved slot, got %q", line)
+	}
+
+	// The admin slot is single use
+	extra, _, line := dialTestServer(t, addr, '\n')
+	defer extra.Close()
+	if line != "Chatroom full...\n" {
+		t.Errorf("Expected the server to be full, got %q", line)
+	}
+}
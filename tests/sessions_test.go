@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"netcat/server"
+)
+
+func TestResumeAfterDroppedConnection(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithResumeGrace(time.Minute))
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	alice.Write([]byte("alice\n"))
+	token := resumeToken(t, aliceReader)
+	alice.Write([]byte("/join dev\n"))
+	readUntil(t, aliceReader, "You joined #dev.")
+
+	bob, bobReader, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+	resumeToken(t, bobReader)
+	bob.Write([]byte("/join dev\n"))
+	readUntil(t, aliceReader, "bob has joined #dev")
+
+	// The connection drops: nobody is told, and the name stays taken
+	alice.Close()
+	time.Sleep(50 * time.Millisecond)
+	bob.Write([]byte("/rename Alice\n"))
+	readExpecting(t, bobReader, "name is already taken", "alice has left")
+	bob.Write([]byte("said while you were away\n"))
+	readExpecting(t, bobReader, "[bob]: said while you were away", "alice has left")
+	bob.Write([]byte("/msg alice are you there?\n"))
+	readExpecting(t, bobReader, "[DM to alice]: are you there?", "alice has left")
+
+	back, backReader, _ := dialTestServer(t, addr, ':')
+	defer back.Close()
+	back.Write([]byte("/resume not-a-token\n"))
+	readUntil(t, backReader, "Unknown or expired resume token.")
+	if _, err := backReader.ReadString(':'); err != nil {
+		t.Fatalf("Expected the name prompt again: %v", err)
+	}
+	back.Write([]byte("/resume " + token + "\n"))
+	readUntil(t, backReader, "Welcome back, alice.")
+	readUntil(t, backReader, "[bob]: said while you were away")
+	readUntil(t, backReader, "[DM from bob]: are you there?")
+	if next := resumeToken(t, backReader); next == "" || next == token {
+		t.Errorf("Expected a new token after resuming, got %q", next)
+	}
+
+	// Back in #dev with the same reply target, and no join announced
+	bob.Write([]byte("welcome back\n"))
+	readExpecting(t, bobReader, "[bob]: welcome back", "alice has joined")
+	readUntil(t, backReader, "[bob]: welcome back")
+	back.Write([]byte("/reply thanks\n"))
+	readUntil(t, bobReader, "[DM from alice]: thanks")
+
+	// A token works only once
+	again, againReader, _ := dialTestServer(t, addr, ':')
+	defer again.Close()
+	again.Write([]byte("/resume " + token + "\n"))
+	readUntil(t, againReader, "Unknown or expired resume token.")
+}
+
+func TestResumeGraceExpires(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithResumeGrace(100*time.Millisecond))
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	bob, bobReader, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+	resumeToken(t, bobReader)
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	alice.Write([]byte("alice\n"))
+	token := resumeToken(t, aliceReader)
+	readUntil(t, bobReader, "alice has joined")
+
+	// Once the grace period is over the departure is announced
+	alice.Close()
+	readUntil(t, bobReader, "alice has left our chat.")
+
+	late, lateReader, _ := dialTestServer(t, addr, ':')
+	defer late.Close()
+	late.Write([]byte("/resume " + token + "\n"))
+	readUntil(t, lateReader, "Unknown or expired resume token.")
+	lateReader.ReadString(':')
+	late.Write([]byte("alice\n"))
+	resumeToken(t, lateReader)
+}
+
+func TestQuitEndsSession(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644); err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0), server.WithResumeGrace(time.Minute))
+	defer srv.Shutdown(context.Background())
+	addr := startTestServer(t, srv)
+
+	bob, bobReader, _ := dialTestServer(t, addr, ':')
+	defer bob.Close()
+	bob.Write([]byte("bob\n"))
+	resumeToken(t, bobReader)
+
+	alice, aliceReader, _ := dialTestServer(t, addr, ':')
+	defer alice.Close()
+	alice.Write([]byte("alice\n"))
+	token := resumeToken(t, aliceReader)
+	alice.Write([]byte("/quit\n"))
+	readUntil(t, bobReader, "alice has left our chat.")
+
+	conn, reader, _ := dialTestServer(t, addr, ':')
+	defer conn.Close()
+	conn.Write([]byte("/resume " + token + "\n"))
+	readUntil(t, reader, "Unknown or expired resume token.")
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/models"
+	"netcat/style"
+)
+
+func TestMarkup(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"plain text", "plain text"},
+		{"this is *important*", "this is \x1b[1mimportant\x1b[22m"},
+		{"_quietly_ now", "\x1b[3mquietly\x1b[23m now"},
+		{"*a* and *b*!", "\x1b[1ma\x1b[22m and \x1b[1mb\x1b[22m!"},
+		{"*_both_*", "\x1b[1m\x1b[3mboth\x1b[23m\x1b[22m"},
+		{"snake_case_name", "snake_case_name"},
+		{"2*3*4", "2*3*4"},
+		{"* not bold *", "* not bold *"},
+		{"*unclosed", "*unclosed"},
+		{"**", "**"},
+	}
+	for _, tt := range tests {
+		if got := style.Markup(tt.input); got != tt.expected {
+			t.Errorf("Markup(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNameColorsAreStable(t *testing.T) {
+	if style.Color("Alice") != style.Color("alice") {
+		t.Error("Expected a name's color to ignore case")
+	}
+
+	seen := make(map[int]bool)
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi"} {
+		seen[style.Color(name)] = true
+	}
+	if len(seen) < 3 {
+		t.Errorf("Expected names to be spread over the palette, got %v", seen)
+	}
+
+	if got := style.Name("bob"); !strings.Contains(got, "bob") || !strings.HasPrefix(got, "\x1b[") || !strings.HasSuffix(got, "\x1b[39m") {
+		t.Errorf("Expected bob in color, got %q", got)
+	}
+}
+
+func TestRenderInColor(t *testing.T) {
+	at := time.Date(2025, 3, 1, 22, 30, 0, 0, time.UTC)
+	msg := models.Message{Kind: models.KindChat, Sender: "alice", Body: "*hi*", Time: at}
+
+	plain := msg.Render("bob", models.Prefs{Location: time.UTC})
+	if plain != "[2025-03-01 22:30:00][alice]: *hi*\n" {
+		t.Errorf("Expected plain text without color, got %q", plain)
+	}
+	if strings.Contains(msg.Line(), "\x1b") {
+		t.Errorf("Expected the log line in plain text, got %q", msg.Line())
+	}
+
+	colored := msg.Render("bob", models.Prefs{Location: time.UTC, Color: true})
+	expected := "[2025-03-01 22:30:00][" + style.Name("alice") + "]: \x1b[1mhi\x1b[22m\n"
+	if colored != expected {
+		t.Errorf("Expected %q, got %q", expected, colored)
+	}
+
+	line := models.Prefs{Location: time.UTC, Color: true}.Render("[2025-03-01T22:30:00Z][alice]: *hi*")
+	if line+"\n" != expected {
+		t.Errorf("Expected history in color, got %q", line)
+	}
+}
+
+func TestHandleClientSetColor(t *testing.T) {
+	if err := os.WriteFile("logo.txt", []byte("Welcome!"), 0644); err != nil {
+		t.Fatalf("Failed to create logo.txt: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	hub := models.NewHub()
+	hub.Broadcast = make(chan models.Message, 10)
+
+	client, reader, _ := joinTestClient(t, hub, "painter")
+	defer client.Close()
+
+	client.Write([]byte("/set color on\n"))
+	if line := readUntil(t, reader, "\n"); !strings.Contains(line, style.Name("painter")) {
+		t.Errorf("Expected the confirmation to show the name in color, got %q", line)
+	}
+	client.Write([]byte("/set color maybe\n"))
+	if line := readUntil(t, reader, "\n"); !strings.HasPrefix(line, "Usage:") {
+		t.Errorf("Expected usage, got %q", line)
+	}
+
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+	for conn, client := range hub.Clients {
+		if client.Name == "painter" && !hub.Prefs[conn].Color {
+			t.Error("Expected color to be on")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"netcat/certs"
+	"netcat/server"
+)
+
+func TestPlainAndTLSSideBySide(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	cert, err := certs.SelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+
+	srv := server.New(server.WithLogWriter(io.Discard), server.WithShutdownGrace(0))
+	defer srv.Shutdown(context.Background())
+	plainAddr := startTestServer(t, srv)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.ServeTLS(ln, certs.ServerConfig(cert))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	secure, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", ln.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("Failed to connect over TLS: %v", err)
+	}
+	defer secure.Close()
+	secureReader := bufio.NewReader(secure)
+	secure.SetReadDeadline(time.Now().Add(2 * time.Second))
+	readUntil(t, secureReader, "Welcome")
+	if _, err := secureReader.ReadString(':'); err != nil {
+		t.Fatalf("Failed to read the name prompt over TLS: %v", err)
+	}
+	secure.Write([]byte("alice\n"))
+	readUntil(t, secureReader, "\n") // history
+
+	plain, plainReader, _ := dialTestServer(t, plainAddr, ':')
+	defer plain.Close()
+	plain.Write([]byte("bob\n"))
+	readUntil(t, secureReader, "bob has joined")
+
+	// Both listeners feed the same rooms
+	secure.Write([]byte("sent over TLS\n"))
+	readUntil(t, plainReader, "[alice]: sent over TLS")
+	plain.Write([]byte("sent in the clear\n"))
+	readUntil(t, secureReader, "[bob]: sent in the clear")
+
+	if addrs := srv.Addrs(); len(addrs) != 2 {
+		t.Errorf("Expected two listeners, got %v", addrs)
+	}
+
+	// A client that does not trust the certificate cannot connect
+	if conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{}); err == nil {
+		conn.Close()
+		t.Error("Expected an untrusted certificate to be refused")
+	}
+}
+
+func TestLogNameDoesNotDependOnListener(t *testing.T) {
+	err := os.WriteFile("logo.txt", []byte("Welcome to TCP Chat!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create logo file: %v", err)
+	}
+	defer os.Remove("logo.txt")
+
+	cert, err := certs.SelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+
+	logDir := t.TempDir()
+	srv := server.New(server.WithLogDir(logDir), server.WithLogName("9060"), server.WithShutdownGrace(0))
+	defer srv.Shutdown(context.Background())
+
+	// The TLS listener starts first
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.ServeTLS(ln, certs.ServerConfig(cert))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	secure, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", ln.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("Failed to connect over TLS: %v", err)
+	}
+	defer secure.Close()
+	secure.SetReadDeadline(time.Now().Add(2 * time.Second))
+	readUntil(t, bufio.NewReader(secure), "Welcome")
+
+	if _, err := os.Stat(filepath.Join(logDir, "chat_log_9060.log")); err != nil {
+		t.Errorf("Expected the log to be named after the configured name: %v", err)
+	}
+	if entries, _ := os.ReadDir(logDir); len(entries) != 1 {
+		t.Errorf("Expected only the lobby log, got %v", entries)
+	}
+}
+
+func TestGenCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	args := []string{"-host", "chat.example.com,10.0.0.5", "-cert", certFile, "-key", keyFile, "-valid-for", "24h"}
+
+	var out strings.Builder
+	if err := certs.Run(args, &out, io.Discard); err != nil {
+		t.Fatalf("gencert failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "chat.example.com, 10.0.0.5") {
+		t.Errorf("Expected the hosts to be reported, got %q", out.String())
+	}
+
+	cfg, err := certs.Load(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load the generated files: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse the certificate: %v", err)
+	}
+	if err := leaf.VerifyHostname("10.0.0.5"); err != nil {
+		t.Errorf("Expected the certificate to cover the IP address: %v", err)
+	}
+	if leaf.NotAfter.After(time.Now().Add(25 * time.Hour)) {
+		t.Errorf("Expected the certificate to expire within a day, got %v", leaf.NotAfter)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the key to be private, got %v (err: %v)", info.Mode(), err)
+	}
+
+	if err := certs.Run(args, io.Discard, io.Discard); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected existing files to be kept, got %v", err)
+	}
+	if err := certs.Run(append(args, "-force"), io.Discard, io.Discard); err != nil {
+		t.Errorf("Expected -force to overwrite, got %v", err)
+	}
+}
@@ -1,12 +1,10 @@
 package tests
 
 import (
-	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 
@@ -17,8 +15,8 @@ import (
 func TestLogToFile(t *testing.T) {
 	// Test when LogFile is nil
 	t.Run("LogFile is nil", func(t *testing.T) {
-		models.LogFile = nil
-		LogToFile("test message")
+		room := models.NewRoom(models.Lobby)
+		LogToFile(room, "test message")
 		// Should not panic or error
 	})
 
@@ -32,9 +30,10 @@ func TestLogToFile(t *testing.T) {
 		defer os.Remove(tmpFile.Name())
 		defer tmpFile.Close()
 
-		models.LogFile = tmpFile
+		room := models.NewRoom(models.Lobby)
+		room.LogFile = tmpFile
 		testMsg := "test message\n"
-		LogToFile(testMsg)
+		LogToFile(room, testMsg)
 
 		// Read back the content
 		tmpFile.Seek(0, 0)
@@ -49,153 +48,56 @@ func TestLogToFile(t *testing.T) {
 	})
 }
 
-func TestSendChatHistory(t *testing.T) {
-	// Test when file doesn't exist
-	t.Run("File doesn't exist", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer server.Close()
-		defer client.Close()
-
-		// Use a WaitGroup to ensure we wait for SendChatHistory to complete
-		var wg sync.WaitGroup
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			SendChatHistory(server, "nonexistent.txt")
-		}()
-
-		// Set read deadline to prevent hanging
-		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+func TestSendDirect(t *testing.T) {
+	hub := models.NewHub()
+
+	aliceServer, aliceClient := net.Pipe()
+	bobServer, bobClient := net.Pipe()
+	eveServer, eveClient := net.Pipe()
+	defer aliceServer.Close()
+	defer aliceClient.Close()
+	defer bobServer.Close()
+	defer bobClient.Close()
+	defer eveServer.Close()
+	defer eveClient.Close()
+
+	hub.Clients[aliceServer] = models.NewClient(aliceServer, "alice")
+	hub.Clients[bobServer] = models.NewClient(bobServer, "Bob")
+	hub.Clients[eveServer] = models.NewClient(eveServer, "eve")
+
+	readLine := func(conn net.Conn, lines chan<- string) {
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
 		buffer := make([]byte, 1024)
-		n, err := client.Read(buffer)
-		if err != nil {
-			t.Fatalf("Failed to read from connection: %v", err)
-		}
-		response := string(buffer[:n])
-
-		if !strings.Contains(response, "[No chat history available]") {
-			t.Errorf("Expected no chat history message, got: %s", response)
-		}
-
-		wg.Wait()
-	})
-
-	// Test with existing file
-	t.Run("File exists with history", func(t *testing.T) {
-		// Create temporary file with content
-		tmpFile, err := ioutil.TempFile("", "test_history")
-		if err != nil {
-			t.Fatalf("Failed to create temp file: %v", err)
-		}
-		defer os.Remove(tmpFile.Name())
-
-		testContent := "line1\nline2\nline3\n"
-		if _, err := tmpFile.WriteString(testContent); err != nil {
-			t.Fatalf("Failed to write to temp file: %v", err)
-		}
-		tmpFile.Close()
-
-		server, client := net.Pipe()
-		defer server.Close()
-		defer client.Close()
-
-		// Use a WaitGroup to ensure we wait for SendChatHistory to complete
-		var wg sync.WaitGroup
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			SendChatHistory(server, tmpFile.Name())
-		}()
-
-		// Read with timeout and accumulate all lines
-		client.SetReadDeadline(time.Now().Add(2 * time.Second))
-		var receivedContent strings.Builder
-		buffer := make([]byte, 1024)
-
-		for {
-			n, err := client.Read(buffer)
-			if err != nil {
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					break // Break on timeout, we might have all the data
-				}
-				if err == io.EOF {
-					break // Normal end of transmission
-				}
-				t.Fatalf("Error reading from connection: %v", err)
-			}
-			receivedContent.Write(buffer[:n])
-
-			// Break if we've received all expected lines
-			if strings.Count(receivedContent.String(), "\n") >= 3 {
-				break
-			}
-		}
+		n, _ := conn.Read(buffer)
+		lines <- string(buffer[:n])
+	}
 
-		response := receivedContent.String()
-		expectedLines := []string{"line1", "line2", "line3"}
-		for _, line := range expectedLines {
-			if !strings.Contains(response, line) {
-				t.Errorf("Expected response to contain %q, got: %s", line, response)
-			}
-		}
+	bobLines := make(chan string, 1)
+	aliceLines := make(chan string, 1)
+	eveLines := make(chan string, 1)
+	go readLine(bobClient, bobLines)
+	go readLine(aliceClient, aliceLines)
+	go readLine(eveClient, eveLines)
 
-		wg.Wait()
-	})
-}
+	if err := SendDirect(hub, aliceServer, "bob", "psst"); err != nil {
+		t.Fatalf("SendDirect failed: %v", err)
+	}
 
-func TestNotifyClients(t *testing.T) {
-	// Reset clients map before test
-	models.Mu.Lock()
-	models.Clients = make(map[net.Conn]string)
-	models.Mu.Unlock()
-
-	// Create test connections
-	server1, client1 := net.Pipe()
-	server2, client2 := net.Pipe()
-	server3, client3 := net.Pipe()
-	defer server1.Close()
-	defer client1.Close()
-	defer server2.Close()
-	defer client2.Close()
-	defer server3.Close()
-	defer client3.Close()
-
-	// Add clients to the map
-	models.Mu.Lock()
-	models.Clients[server1] = "User1"
-	models.Clients[server2] = "User2"
-	models.Clients[server3] = "User3"
-	models.Mu.Unlock()
-
-	testMessage := "Test broadcast message\n"
-
-	// Notify all clients except server2
-	go NotifyClients(server2, testMessage)
-
-	// Check if server1 and server3 received the message
-	checkMessage := func(client net.Conn, shouldReceive bool) {
-		client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-		buffer := make([]byte, 1024)
-		n, err := client.Read(buffer)
-
-		if shouldReceive {
-			if err != nil {
-				t.Errorf("Expected to receive message, but got error: %v", err)
-				return
-			}
-			if string(buffer[:n]) != testMessage {
-				t.Errorf("Expected %q, got %q", testMessage, string(buffer[:n]))
-			}
-		} else {
-			if err == nil {
-				t.Errorf("Expected not to receive message, but got: %q", string(buffer[:n]))
-			}
-		}
+	if line := <-bobLines; !strings.Contains(line, "[DM from alice]: psst") {
+		t.Errorf("Expected recipient to get a marked DM, got %q", line)
+	}
+	if line := <-aliceLines; !strings.Contains(line, "[DM to Bob]: psst") {
+		t.Errorf("Expected sender to get an echo, got %q", line)
+	}
+	if line := <-eveLines; line != "" {
+		t.Errorf("Other clients should not see DMs, got %q", line)
 	}
 
-	go checkMessage(client1, true)  // Should receive
-	go checkMessage(client3, true)  // Should receive
-	go checkMessage(client2, false) // Should not receive
+	if hub.ReplyTo[bobServer] != "alice" {
+		t.Errorf("Expected bob's reply target to be alice, got %q", hub.ReplyTo[bobServer])
+	}
 
-	time.Sleep(200 * time.Millisecond)
+	if err := SendDirect(hub, aliceServer, "nobody", "hello?"); err != ErrNoSuchUser {
+		t.Errorf("Expected ErrNoSuchUser, got %v", err)
+	}
 }
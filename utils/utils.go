@@ -1,49 +1,115 @@
 package utils
 
 import (
-	"bufio"
+	"errors"
 	"log"
 	"net"
-	"os"
+	"strings"
 
 	"netcat/models"
+	"netcat/names"
+	"netcat/presence"
+	"netcat/sessions"
 )
 
-// LogToFile writes messages to the chat log file
-func LogToFile(msg string) {
-	if models.LogFile == nil {
+// LogToFile writes messages to the room's chat log
+func LogToFile(room *models.Room, msg string) {
+	if room.LogFile == nil {
 		return
 	}
 
-	_, err := models.LogFile.WriteString(msg)
+	_, err := room.LogFile.Write([]byte(msg))
 	if err != nil {
 		log.Printf("Error writing to log file: %v", err)
 	}
 }
 
-// SendChatHistory sends chat history to a newly connected client
-func SendChatHistory(conn net.Conn, fileName string) {
-	file, err := os.Open(fileName)
-	if err != nil {
-		conn.Write([]byte("[No chat history available]\n"))
+// SendRecentHistory sends a client the last limit lines a room keeps in
+// memory, or all of them when limit is not positive, stamped in the
+// client's preferred time format
+func SendRecentHistory(hub *models.Hub, conn net.Conn, room *models.Room, limit int) {
+	lines := room.History.Last(limit)
+	if len(lines) == 0 {
+		Send(hub, conn, "[No chat history available]\n")
 		return
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		conn.Write([]byte(scanner.Text() + "\n"))
+	hub.Mu.Lock()
+	prefs := hub.Prefs[conn]
+	hub.Mu.Unlock()
+
+	// One message, so a long history cannot overflow the outbox
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(prefs.Render(line) + "\n")
+	}
+	Send(hub, conn, b.String())
+}
+
+// Deliver queues a message on the outbox of conn, or writes it directly to
+// connections without one. It reports false when the client can no longer
+// receive messages. The caller must hold hub.Mu.
+func Deliver(hub *models.Hub, conn net.Conn, message string) bool {
+	if box, ok := hub.Outboxes[conn]; ok {
+		return box.Send(message)
+	}
+
+	_, err := conn.Write([]byte(message))
+	return err == nil
+}
+
+// Send queues message on the outbox of conn, behind everything else on its
+// way to the client, so replies and broadcasts reach it in order and only
+// the outbox writer ever writes to the connection. Connections without an
+// outbox are written to directly.
+func Send(hub *models.Hub, conn net.Conn, message string) {
+	hub.Mu.Lock()
+	box, ok := hub.Outboxes[conn]
+	hub.Mu.Unlock()
+
+	if ok {
+		box.Send(message)
+		return
 	}
+	conn.Write([]byte(message))
 }
 
-// NotifyClients sends a message to all clients except the excluded one
-func NotifyClients(excludeConn net.Conn, message string) {
-	models.Mu.Lock()
-	defer models.Mu.Unlock()
+// ErrNoSuchUser is returned by SendDirect when no client has the given name
+var ErrNoSuchUser = errors.New("no such user")
+
+// SendDirect delivers a private message from one client to the client named
+// to, echoing it back to the sender. The recipient's /reply target is set to
+// the sender. Direct messages never pass through the broadcaster, so they
+// are not written to the room logs.
+func SendDirect(hub *models.Hub, from net.Conn, to string, text string) error {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
 
-	for conn := range models.Clients {
-		if conn != excludeConn {
-			conn.Write([]byte(message))
+	sender := hub.Name(from)
+	msg := models.NewMessage(models.KindDirect, sender, "", text)
+	msg.ID = hub.NextID()
+
+	target, name, ok := names.Find(hub, to)
+	if !ok {
+		// A user whose connection dropped gets the message on resuming
+		session, waiting := sessions.Find(hub, to)
+		if !waiting {
+			return ErrNoSuchUser
 		}
+		msg.Meta = map[string]string{"to": session.Name}
+		sessions.Keep(hub, session, msg)
+		session.ReplyTo = sender
+		Deliver(hub, from, msg.Render(sender, hub.Prefs[from]))
+		return nil
+	}
+
+	msg.Meta = map[string]string{"to": name}
+	Deliver(hub, target, msg.Render(name, hub.Prefs[target]))
+	Deliver(hub, from, msg.Render(sender, hub.Prefs[from]))
+	if away := hub.Clients[target].Away; away != "" {
+		Deliver(hub, from, presence.AwayNotice(name, away))
 	}
+
+	hub.ReplyTo[target] = sender
+	return nil
 }